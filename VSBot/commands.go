@@ -0,0 +1,426 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strconv"
+import "regexp"
+import "strings"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+func relayCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" {
+		return
+	}
+
+	if !isAdmin(s, m.Author.ID, m.ChannelID) {
+		s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+		return
+	}
+
+	command := parseCommand(m.Content)
+	if len(command) < 2 {
+		command = append(command, "help")
+	}
+
+	switch command[1] {
+	case "add":
+		if len(command) < 4 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!relay add #from #to [provider or kind...]`")
+			return
+		}
+		from, ok := guildChannel(s, m.GuildID, command[2])
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Invalid source channel: "+command[2])
+			return
+		}
+		to, ok := guildChannel(s, m.GuildID, command[3])
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Invalid destination channel: "+command[3])
+			return
+		}
+
+		if bad, ok := validateMatchers(command[4:]); !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown matcher `"+bad+"`, use a provider ("+providerNames()+") or a kind ("+kindNames()+").")
+			return
+		}
+
+		id, err := addRule(relayRule{Guild: m.GuildID, FromChannel: from, ToChannel: to, Matchers: command[4:]})
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Rule add error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added rule %d.", id))
+	case "rm":
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+		id, err := strconv.ParseInt(command[2], 10, 64)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Invalid rule ID: "+command[2])
+			return
+		}
+
+		ok, err := removeRule(id, m.GuildID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Rule remove error:", err)
+			return
+		}
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "No such rule.")
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed rule %d.", id))
+	case "mod":
+		if len(command) < 4 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!relay mod id #channel` or `!relay mod id off`")
+			return
+		}
+		id, err := strconv.ParseInt(command[2], 10, 64)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Invalid rule ID: "+command[2])
+			return
+		}
+		mod := ""
+		if command[3] != "off" {
+			var ok bool
+			mod, ok = guildChannel(s, m.GuildID, command[3])
+			if !ok {
+				s.ChannelMessageSend(m.ChannelID, "Invalid mod channel: "+command[3])
+				return
+			}
+		}
+
+		ok, err := setRuleMod(id, m.GuildID, mod)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Rule mod error:", err)
+			return
+		}
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "No such rule.")
+			return
+		}
+		if mod == "" {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Rule %d no longer needs approval.", id))
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Rule %d relays now need approval in <#%s>.", id, mod))
+	case "list":
+		rules, err := getRules(m.GuildID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Rule list error:", err)
+			return
+		}
+		msg := "Relay Rules:"
+		for _, rule := range rules {
+			msg += "\n" + describeRule(rule)
+		}
+		s.ChannelMessageSend(m.ChannelID, msg)
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Try: `!relay add #from #to [provider or kind...]`, `!relay rm id`, `!relay mod id #channel|off`, or `!relay list` (admin only)\nProviders: "+providerNames()+"\nKinds: "+kindNames())
+	}
+}
+
+var streamChannelPatterns = map[string]*regexp.Regexp{
+	"twitch":  regexp.MustCompile(`^\w{3,25}$`),
+	"youtube": regexp.MustCompile(`^UC[\w-]{22}$`),
+}
+
+func streamCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" {
+		return
+	}
+
+	command := parseCommand(m.Content)
+	if len(command) < 2 {
+		command = append(command, "help")
+	}
+
+	switch command[1] {
+	case "link":
+		if len(command) < 4 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!stream link twitch login` or `!stream link youtube channelID`")
+			return
+		}
+		platform, channel := strings.ToLower(command[2]), command[3]
+		pattern, ok := streamChannelPatterns[platform]
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown platform: "+command[2])
+			return
+		}
+		if !pattern.MatchString(channel) {
+			s.ChannelMessageSend(m.ChannelID, "That doesn't look like a "+platform+" channel.")
+			return
+		}
+		if platform == "twitch" {
+			channel = strings.ToLower(channel)
+		}
+
+		endStreamerLink(s, m.Author.ID, m.GuildID, platform)
+		err := addStreamer(streamer{User: m.Author.ID, Guild: m.GuildID, Platform: platform, Channel: channel})
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Streamer add error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Linked "+platform+" channel: "+channel)
+	case "unlink":
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+		platform := strings.ToLower(command[2])
+		endStreamerLink(s, m.Author.ID, m.GuildID, platform)
+		ok, err := removeStreamer(m.Author.ID, m.GuildID, platform)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Streamer remove error:", err)
+			return
+		}
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "You have no linked "+command[2]+" channel.")
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Unlinked "+command[2]+" channel.")
+	case "list":
+		streamers, err := getUserStreamers(m.Author.ID, m.GuildID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Streamer list error:", err)
+			return
+		}
+		msg := "Your Channels:"
+		for _, st := range streamers {
+			msg += "\n" + st.Platform + ": " + st.Channel
+		}
+		s.ChannelMessageSend(m.ChannelID, msg)
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Try: `!stream link twitch login`, `!stream link youtube channelID`, `!stream unlink platform`, or `!stream list`")
+	}
+}
+
+func modsCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" {
+		return
+	}
+
+	command := parseCommand(m.Content)
+	if len(command) < 2 {
+		command = append(command, "help")
+	}
+
+	switch command[1] {
+	case "watch":
+		if !isAdmin(s, m.Author.ID, m.ChannelID) {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!mods watch mod [#channel]`")
+			return
+		}
+		cid := m.ChannelID
+		if len(command) > 3 {
+			var ok bool
+			cid, ok = guildChannel(s, m.GuildID, command[3])
+			if !ok {
+				s.ChannelMessageSend(m.ChannelID, "Invalid channel: "+command[3])
+				return
+			}
+		}
+
+		mod, err := getModDBMod(command[2])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Couldn't find that mod on the mod DB.")
+			fmt.Println("ModDB Error:", err)
+			return
+		}
+		err = markReleasesSeen(mod)
+		if err == nil {
+			err = addModWatch(modWatch{Guild: m.GuildID, Channel: cid, ModID: mod.ModID, Name: mod.Name})
+		}
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Mod watch error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Now announcing releases of "+mod.Name+" in <#"+cid+">.")
+	case "unwatch":
+		if !isAdmin(s, m.Author.ID, m.ChannelID) {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+
+		// Accept the name from `!mods list` as well as the mod DB ID or alias.
+		watches, err := getModWatches(m.GuildID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Mod watch list error:", err)
+			return
+		}
+		id := int64(-1)
+		for _, w := range watches {
+			if strings.EqualFold(w.Name, command[2]) || fmt.Sprint(w.ModID) == command[2] {
+				id = w.ModID
+			}
+		}
+		if id == -1 {
+			mod, err := getModDBMod(command[2])
+			if err == nil {
+				id = mod.ModID
+			}
+		}
+
+		ok, err := removeModWatch(m.GuildID, id)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Mod unwatch error:", err)
+			return
+		}
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "That mod isn't being watched.")
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "No longer announcing releases of "+command[2]+".")
+	case "list":
+		watches, err := getModWatches(m.GuildID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Mod watch list error:", err)
+			return
+		}
+		msg := "Watched Mods:"
+		for _, w := range watches {
+			msg += fmt.Sprintf("\n%v (%d) in <#%v>", w.Name, w.ModID, w.Channel)
+		}
+		s.ChannelMessageSend(m.ChannelID, msg)
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Try: `!mods list`, `!mods watch mod [#channel]` (admin only), or `!mods unwatch mod` (admin only)")
+	}
+}
+
+func describeRule(r relayRule) string {
+	matchers := "(anything)"
+	if len(r.Matchers) > 0 {
+		matchers = "`" + strings.Join(r.Matchers, "` `") + "`"
+	}
+	mod := ""
+	if r.ModChannel != "" {
+		mod = " (approved in <#" + r.ModChannel + ">)"
+	}
+	return fmt.Sprintf("%d: <#%s> -> <#%s> %s%s", r.ID, r.FromChannel, r.ToChannel, matchers, mod)
+}
+
+func providerNames() string {
+	names := []string{}
+	for _, p := range Providers {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func kindNames() string {
+	names := []string{}
+	for _, k := range Kinds {
+		names = append(names, string(k))
+	}
+	return strings.Join(names, ", ")
+}
+
+func isAdmin(s *discordgo.Session, uid, cid string) bool {
+	perm, err := s.State.UserChannelPermissions(uid, cid)
+	if err != nil {
+		return false
+	}
+	return perm&discordgo.PermissionAdministrator != 0 || perm&discordgo.PermissionManageServer != 0 || perm&discordgo.PermissionManageChannels != 0
+}
+
+// Accepts either a channel mention or a raw ID, and makes sure the channel is in the given guild.
+func guildChannel(s *discordgo.Session, guild, arg string) (string, bool) {
+	id := strings.TrimSuffix(strings.TrimPrefix(arg, "<#"), ">")
+	ch, err := s.State.Channel(id)
+	if err != nil {
+		ch, err = s.Channel(id)
+		if err != nil {
+			return "", false
+		}
+	}
+	return ch.ID, ch.GuildID == guild
+}
+
+// For when strings.Split just isn't good enough...
+func parseCommand(in string) []string {
+	out := make([]string, 0)
+	var buf []byte
+
+	skipwhite := true
+	quotes := false
+	for i := range in {
+		b := in[i]
+
+		// Quoted things
+		if quotes && b != '"' {
+			buf = append(buf, b)
+			continue
+		}
+		if b == '"' {
+			quotes = !quotes
+			out = append(out, string(buf))
+			buf = buf[0:0]
+			continue
+		}
+
+		// White space
+		if skipwhite && (b == ' ' || b == '\t') {
+			continue
+		}
+		if b == ' ' || b == '\t' {
+			skipwhite = true
+			continue
+		}
+		if skipwhite {
+			skipwhite = false
+			if len(buf) > 0 {
+				out = append(out, string(buf))
+			}
+			buf = nil
+		}
+
+		// Everything else
+		buf = append(buf, b)
+	}
+	if len(buf) > 0 {
+		out = append(out, string(buf))
+	}
+	return out
+}
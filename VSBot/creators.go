@@ -0,0 +1,165 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "sort"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// Creators who haven't shared anything in this long aren't listed.
+var CreatorActiveWindow = 90 * 24 * time.Hour
+
+// Works out which channel on the provider a link belongs to. Returns "" for the name if it can't tell.
+func creatorChannel(l Link) (string, string) {
+	if data := getOEmbed(l); data != nil && data.AuthorName != "" {
+		return data.AuthorName, data.AuthorURL
+	}
+
+	// Stream links have the channel right in the path.
+	if l.Kind == KindStream && l.Provider.Name != "youtube" {
+		name := strings.Trim(l.URL.Path, "/")
+		if name != "" && !strings.Contains(name, "/") {
+			return name, "https://" + l.URL.Host + "/" + name
+		}
+	}
+	return "", ""
+}
+
+// Adds the links a user shared to the directory, unless they opted out.
+func recordCreator(guild, uid string, links []Link, now time.Time) {
+	out, err := isOptedOut(uid)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if out {
+		return
+	}
+
+	for _, link := range links {
+		name, url := creatorChannel(link)
+		if name == "" {
+			continue
+		}
+		err := addCreator(creator{Guild: guild, User: uid, Provider: link.Provider.Name, Channel: name, ChannelURL: url, LastShared: now})
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+	}
+}
+
+func creatorsCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" {
+		return
+	}
+
+	command := parseCommand(m.Content)
+	if len(command) < 2 {
+		command = append(command, "list")
+	}
+
+	switch command[1] {
+	case "optout":
+		err := setOptOut(m.Author.ID, true)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Opt out error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "You won't be listed in the creator directory anymore.")
+	case "optin":
+		err := setOptOut(m.Author.ID, false)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Opt in error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "You will be listed in the creator directory the next time you share something.")
+	case "list":
+		creators, err := getCreators(m.GuildID, time.Now().Add(-CreatorActiveWindow))
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Creator list error:", err)
+			return
+		}
+		_, err = s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Embeds:          []*discordgo.MessageEmbed{creatorsEmbed(creators)},
+			AllowedMentions: noMentions,
+		})
+		if err != nil {
+			fmt.Println("Error sending message to:", m.ChannelID, err)
+		}
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Try: `!creators`, `!creators optout`, or `!creators optin`")
+	}
+}
+
+func creatorsEmbed(creators []creator) *discordgo.MessageEmbed {
+	// Group by user, most recently active first.
+	byUser := map[string][]creator{}
+	last := map[string]time.Time{}
+	users := []string{}
+	for _, c := range creators {
+		if _, ok := byUser[c.User]; !ok {
+			users = append(users, c.User)
+		}
+		byUser[c.User] = append(byUser[c.User], c)
+		if c.LastShared.After(last[c.User]) {
+			last[c.User] = c.LastShared
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool { return last[users[i]].After(last[users[j]]) })
+
+	desc := ""
+	for _, uid := range users {
+		channels := []string{}
+		for _, c := range byUser[uid] {
+			if c.ChannelURL != "" {
+				channels = append(channels, fmt.Sprintf("%v [%v](%v)", c.Provider, c.Channel, c.ChannelURL))
+			} else {
+				channels = append(channels, c.Provider+" "+c.Channel)
+			}
+		}
+		line := fmt.Sprintf("<@%v>: %v, last shared <t:%d:R>\n", uid, strings.Join(channels, ", "), last[uid].Unix())
+
+		// Embed descriptions max out at 4096.
+		if len(desc)+len(line) > 4000 {
+			desc += "..."
+			break
+		}
+		desc += line
+	}
+	if desc == "" {
+		desc = "Nobody has shared anything recently."
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Community Creators",
+		Description: desc,
+		Color:       0x7f5a3a,
+		Footer:      &discordgo.MessageEmbedFooter{Text: "Don't want to be listed? Use !creators optout"},
+	}
+}
@@ -0,0 +1,781 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "time"
+
+import _ "github.com/mattn/go-sqlite3"
+import "database/sql"
+
+var DB *sql.DB
+
+// Where the database lives, tests point this somewhere temporary.
+var DBPath = "file:vsbot.db"
+
+var InitCode = `
+create table if not exists Rules (
+	ID integer primary key,
+
+	Guild text,
+	FromChannel text,
+	ToChannel text,
+
+	Matchers text
+);
+
+create table if not exists Relays (
+	SourceID text,
+	Rule integer,
+
+	CID text,
+	MID text
+);
+
+create table if not exists ModQueue (
+	ID integer primary key,
+
+	SourceCID text,
+	SourceID text,
+	Rule integer,
+	Author text,
+	Created integer,
+
+	ModCID text default '',
+	ModMID text default '',
+
+	Status text default 'pending',
+	Moderator text default '',
+	Reason text default '',
+	Decided integer default 0
+);
+
+create table if not exists ModWatches (
+	Guild text,
+	Channel text,
+	ModID integer,
+	Name text
+);
+
+create table if not exists ModReleases (
+	ModID integer,
+	ReleaseID integer,
+
+	primary key (ModID, ReleaseID)
+);
+
+create table if not exists StatusMessages (
+	CID text primary key,
+	MID text
+);
+
+create table if not exists Creators (
+	Guild text,
+	User text,
+	Provider text,
+	Channel text,
+	ChannelURL text,
+	LastShared integer,
+
+	primary key (Guild, User, Provider, Channel)
+);
+
+create table if not exists CreatorOptOut (
+	User text primary key
+);
+
+create table if not exists Webhooks (
+	CID text primary key,
+	ID text,
+	Token text
+);
+
+create table if not exists RecentLinks (
+	URL text,
+	CID text,
+	Time integer
+);
+
+create table if not exists RelayLog (
+	User text,
+	CID text,
+	Time integer
+);
+
+create table if not exists Streamers (
+	ID integer primary key,
+
+	User text,
+	Guild text,
+	Platform text,
+	Channel text,
+
+	LiveID text default ''
+);
+
+create table if not exists LiveAnnouncements (
+	Streamer integer,
+	CID text,
+	MID text
+);
+
+create table if not exists Flags (
+	Name text primary key
+);
+`
+
+// Changes to tables that already existed. Each is run once, errors from a column that is already there are ignored.
+var Migrations = []string{
+	`alter table Relays add column Webhook text default '';`,
+	`alter table Rules add column ModChannel text default '';`,
+}
+
+var Queries = map[string]*queryHolder{
+	"FlagSet": &queryHolder{`insert or ignore into Flags (Name) values (?);`, nil},
+	"FlagGet": &queryHolder{`select count(*) from Flags where Name = ?;`, nil},
+
+	"RuleInsert":   &queryHolder{`insert into Rules (Guild, FromChannel, ToChannel, Matchers) values (?, ?, ?, ?);`, nil},
+	"RuleRemove":   &queryHolder{`delete from Rules where ID = ? and Guild = ?;`, nil},
+	"RuleList":     &queryHolder{`select ID, Guild, FromChannel, ToChannel, Matchers, ModChannel from Rules where Guild = ? order by ID;`, nil},
+	"RuleBySource": &queryHolder{`select ID, Guild, FromChannel, ToChannel, Matchers, ModChannel from Rules where FromChannel = ? order by ID;`, nil},
+	"RuleByID":     &queryHolder{`select ID, Guild, FromChannel, ToChannel, Matchers, ModChannel from Rules where ID = ?;`, nil},
+	"RuleSetMod":   &queryHolder{`update Rules set ModChannel = ? where ID = ? and Guild = ?;`, nil},
+	"RuleCount":    &queryHolder{`select count(*) from Rules;`, nil},
+
+	"RelayInsert": &queryHolder{`insert into Relays (SourceID, Rule, CID, MID, Webhook) values (?, ?, ?, ?, ?);`, nil},
+	"RelayRemove": &queryHolder{`delete from Relays where SourceID = ? and Rule = ?;`, nil},
+	"RelayList":   &queryHolder{`select Rule, CID, MID, Webhook from Relays where SourceID = ?;`, nil},
+
+	"ModInsert":      &queryHolder{`insert into ModQueue (SourceCID, SourceID, Rule, Author, Created) values (?, ?, ?, ?, ?);`, nil},
+	"ModSetMessage":  &queryHolder{`update ModQueue set ModCID = ?, ModMID = ? where ID = ?;`, nil},
+	"ModDecide":      &queryHolder{`update ModQueue set Status = ?, Moderator = ?, Reason = ?, Decided = ? where ID = ? and Status = 'pending';`, nil},
+	"ModGet":         &queryHolder{`select ID, SourceCID, SourceID, Rule, Author, Created, ModCID, ModMID, Status from ModQueue where ID = ?;`, nil},
+	"ModPendingFor":  &queryHolder{`select ID, SourceCID, SourceID, Rule, Author, Created, ModCID, ModMID, Status from ModQueue where SourceID = ? and Status = 'pending';`, nil},
+	"ModStale":       &queryHolder{`select ID, SourceCID, SourceID, Rule, Author, Created, ModCID, ModMID, Status from ModQueue where Created < ? and Status = 'pending';`, nil},
+	"ModReopen":      &queryHolder{`update ModQueue set Status = 'pending', Moderator = '', Reason = '', Decided = 0 where ID = ? and Status = 'approved';`, nil},
+	"ModQueuedCount": &queryHolder{`select count(*) from ModQueue where SourceID = ? and Rule = ? and Status in ('pending', 'rejected', 'expired');`, nil},
+
+	"WatchInsert": &queryHolder{`insert into ModWatches (Guild, Channel, ModID, Name) values (?, ?, ?, ?);`, nil},
+	"WatchRemove": &queryHolder{`delete from ModWatches where Guild = ? and ModID = ?;`, nil},
+	"WatchList":   &queryHolder{`select Guild, Channel, ModID, Name from ModWatches where (?1 = "" or Guild = ?1) order by Name;`, nil},
+
+	"ReleaseInsert": &queryHolder{`insert or ignore into ModReleases (ModID, ReleaseID) values (?, ?);`, nil},
+	"ReleaseList":   &queryHolder{`select ReleaseID from ModReleases where ModID = ?;`, nil},
+
+	"StatusSet": &queryHolder{`insert or replace into StatusMessages (CID, MID) values (?, ?);`, nil},
+	"StatusGet": &queryHolder{`select MID from StatusMessages where CID = ?;`, nil},
+
+	"CreatorInsert": &queryHolder{`insert or replace into Creators (Guild, User, Provider, Channel, ChannelURL, LastShared) values (?, ?, ?, ?, ?, ?);`, nil},
+	"CreatorList":   &queryHolder{`select Guild, User, Provider, Channel, ChannelURL, LastShared from Creators where Guild = ? and LastShared >= ? order by LastShared desc;`, nil},
+	"CreatorForget": &queryHolder{`delete from Creators where User = ?;`, nil},
+	"OptOutInsert":  &queryHolder{`insert or ignore into CreatorOptOut (User) values (?);`, nil},
+	"OptOutRemove":  &queryHolder{`delete from CreatorOptOut where User = ?;`, nil},
+	"OptOutCount":   &queryHolder{`select count(*) from CreatorOptOut where User = ?;`, nil},
+
+	"WebhookInsert": &queryHolder{`insert or replace into Webhooks (CID, ID, Token) values (?, ?, ?);`, nil},
+	"WebhookRemove": &queryHolder{`delete from Webhooks where CID = ?;`, nil},
+	"WebhookGet":    &queryHolder{`select CID, ID, Token from Webhooks where CID = ?;`, nil},
+
+	"RecentInsert":    &queryHolder{`insert into RecentLinks (URL, CID, Time) values (?, ?, ?);`, nil},
+	"RecentCount":     &queryHolder{`select count(*) from RecentLinks where URL = ? and CID = ? and Time >= ?;`, nil},
+	"RecentPrune":     &queryHolder{`delete from RecentLinks where Time < ?;`, nil},
+	"LogInsert":       &queryHolder{`insert into RelayLog (User, CID, Time) values (?, ?, ?);`, nil},
+	"LogCountUser":    &queryHolder{`select count(*) from RelayLog where User = ? and Time >= ?;`, nil},
+	"LogCountChannel": &queryHolder{`select count(*) from RelayLog where CID = ? and Time >= ?;`, nil},
+	"LogPrune":        &queryHolder{`delete from RelayLog where Time < ?;`, nil},
+
+	"StreamerInsert":   &queryHolder{`insert into Streamers (User, Guild, Platform, Channel) values (?, ?, ?, ?);`, nil},
+	"StreamerRemove":   &queryHolder{`delete from Streamers where User = ? and Guild = ? and Platform = ?;`, nil},
+	"StreamerList":     &queryHolder{`select ID, User, Guild, Platform, Channel, LiveID from Streamers where Platform = ?;`, nil},
+	"StreamerByUser":   &queryHolder{`select ID, User, Guild, Platform, Channel, LiveID from Streamers where User = ? and Guild = ?;`, nil},
+	"StreamerSetLive":  &queryHolder{`update Streamers set LiveID = ? where ID = ?;`, nil},
+	"StreamerDropLive": &queryHolder{`delete from LiveAnnouncements where Streamer = ?;`, nil},
+
+	"LiveInsert": &queryHolder{`insert into LiveAnnouncements (Streamer, CID, MID) values (?, ?, ?);`, nil},
+	"LiveList":   &queryHolder{`select CID, MID from LiveAnnouncements where Streamer = ?;`, nil},
+}
+
+type relayRule struct {
+	ID          int64
+	Guild       string
+	FromChannel string
+	ToChannel   string
+
+	// Empty means "anything".
+	Matchers []string
+
+	// If set, relays must be approved in this channel first.
+	ModChannel string
+}
+
+func addRule(r relayRule) (int64, error) {
+	res, err := Queries["RuleInsert"].Preped.Exec(r.Guild, r.FromChannel, r.ToChannel, strings.Join(r.Matchers, " "))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Returns false if there was no such rule in the guild.
+func removeRule(id int64, guild string) (bool, error) {
+	res, err := Queries["RuleRemove"].Preped.Exec(id, guild)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func getRules(guild string) ([]relayRule, error) {
+	return queryRules(Queries["RuleList"].Preped.Query(guild))
+}
+
+func getRulesFrom(cid string) ([]relayRule, error) {
+	return queryRules(Queries["RuleBySource"].Preped.Query(cid))
+}
+
+// Returns nil if there is no such rule.
+func getRule(id int64) (*relayRule, error) {
+	rules, err := queryRules(Queries["RuleByID"].Preped.Query(id))
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return &rules[0], nil
+}
+
+// Pass "" to turn moderation off.
+func setRuleMod(id int64, guild, cid string) (bool, error) {
+	res, err := Queries["RuleSetMod"].Preped.Exec(cid, id, guild)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Flags record one time things that have been done, like seeding the rules.
+func setFlag(name string) error {
+	_, err := Queries["FlagSet"].Preped.Exec(name)
+	return err
+}
+
+func hasFlag(name string) (bool, error) {
+	n := 0
+	err := Queries["FlagGet"].Preped.QueryRow(name).Scan(&n)
+	return n > 0, err
+}
+
+func countRules() (int, error) {
+	n := 0
+	err := Queries["RuleCount"].Preped.QueryRow().Scan(&n)
+	return n, err
+}
+
+func queryRules(rows *sql.Rows, err error) ([]relayRule, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []relayRule{}
+	for rows.Next() {
+		r, matchers := relayRule{}, ""
+		err := rows.Scan(&r.ID, &r.Guild, &r.FromChannel, &r.ToChannel, &matchers, &r.ModChannel)
+		if err != nil {
+			return nil, err
+		}
+		r.Matchers = strings.Fields(matchers)
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func addRelay(source string, rule int64, relayed message) error {
+	_, err := Queries["RelayInsert"].Preped.Exec(source, rule, relayed.CID, relayed.MID, relayed.Webhook)
+	return err
+}
+
+func removeRelay(source string, rule int64) error {
+	_, err := Queries["RelayRemove"].Preped.Exec(source, rule)
+	return err
+}
+
+// Returns the relayed copies of a source message, keyed by rule ID.
+func getRelays(source string) (map[int64]message, error) {
+	rows, err := Queries["RelayList"].Preped.Query(source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	relays := map[int64]message{}
+	for rows.Next() {
+		rule, f := int64(0), message{}
+		err := rows.Scan(&rule, &f.CID, &f.MID, &f.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		relays[rule] = f
+	}
+	return relays, nil
+}
+
+type modItem struct {
+	ID        int64
+	SourceCID string
+	SourceID  string
+	Rule      int64
+	Author    string
+	Created   time.Time
+
+	ModCID string
+	ModMID string
+
+	Status string
+}
+
+func addModItem(item modItem) (int64, error) {
+	res, err := Queries["ModInsert"].Preped.Exec(item.SourceCID, item.SourceID, item.Rule, item.Author, item.Created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func setModMessage(id int64, cid, mid string) error {
+	_, err := Queries["ModSetMessage"].Preped.Exec(cid, mid, id)
+	return err
+}
+
+// Records a decision. Returns false if the item was already decided.
+func decideModItem(id int64, status, moderator, reason string, t time.Time) (bool, error) {
+	res, err := Queries["ModDecide"].Preped.Exec(status, moderator, reason, t.Unix(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func setModDecision(id int64, status, moderator, reason string, t time.Time) error {
+	_, err := decideModItem(id, status, moderator, reason, t)
+	return err
+}
+
+// Returns nil if there is no such item.
+func getModItem(id int64) (*modItem, error) {
+	items, err := queryModItems(Queries["ModGet"].Preped.Query(id))
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return &items[0], nil
+}
+
+func getPendingFor(source string) ([]modItem, error) {
+	return queryModItems(Queries["ModPendingFor"].Preped.Query(source))
+}
+
+// Pending items created before the given time.
+func getStalePending(before time.Time) ([]modItem, error) {
+	return queryModItems(Queries["ModStale"].Preped.Query(before.Unix()))
+}
+
+// Puts an approved item back in the queue, for when the relay couldn't be sent after all.
+func reopenModItem(id int64) error {
+	_, err := Queries["ModReopen"].Preped.Exec(id)
+	return err
+}
+
+// Reports if the message was already queued for the rule and is still waiting, or was turned down.
+func hasQueued(source string, rule int64) (bool, error) {
+	n := 0
+	err := Queries["ModQueuedCount"].Preped.QueryRow(source, rule).Scan(&n)
+	return n > 0, err
+}
+
+func queryModItems(rows *sql.Rows, err error) ([]modItem, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []modItem{}
+	for rows.Next() {
+		item, created := modItem{}, int64(0)
+		err := rows.Scan(&item.ID, &item.SourceCID, &item.SourceID, &item.Rule, &item.Author, &created, &item.ModCID, &item.ModMID, &item.Status)
+		if err != nil {
+			return nil, err
+		}
+		item.Created = time.Unix(created, 0)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+type modWatch struct {
+	Guild   string
+	Channel string
+	ModID   int64
+	Name    string
+}
+
+// Replaces any existing watch on the same mod in the guild.
+func addModWatch(w modWatch) error {
+	_, err := Queries["WatchRemove"].Preped.Exec(w.Guild, w.ModID)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["WatchInsert"].Preped.Exec(w.Guild, w.Channel, w.ModID, w.Name)
+	return err
+}
+
+func removeModWatch(guild string, mod int64) (bool, error) {
+	res, err := Queries["WatchRemove"].Preped.Exec(guild, mod)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Pass "" as the guild to list all.
+func getModWatches(guild string) ([]modWatch, error) {
+	rows, err := Queries["WatchList"].Preped.Query(guild)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watches := []modWatch{}
+	for rows.Next() {
+		w := modWatch{}
+		err := rows.Scan(&w.Guild, &w.Channel, &w.ModID, &w.Name)
+		if err != nil {
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	return watches, nil
+}
+
+func addSeenRelease(mod, release int64) error {
+	_, err := Queries["ReleaseInsert"].Preped.Exec(mod, release)
+	return err
+}
+
+func getSeenReleases(mod int64) (map[int64]bool, error) {
+	rows, err := Queries["ReleaseList"].Preped.Query(mod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[int64]bool{}
+	for rows.Next() {
+		id := int64(0)
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+	}
+	return seen, nil
+}
+
+func setStatusMessage(cid, mid string) error {
+	_, err := Queries["StatusSet"].Preped.Exec(cid, mid)
+	return err
+}
+
+// Returns "" if there is no status message in the channel.
+func getStatusMessage(cid string) (string, error) {
+	mid := ""
+	err := Queries["StatusGet"].Preped.QueryRow(cid).Scan(&mid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return mid, err
+}
+
+type creator struct {
+	Guild      string
+	User       string
+	Provider   string
+	Channel    string
+	ChannelURL string
+	LastShared time.Time
+}
+
+func addCreator(c creator) error {
+	_, err := Queries["CreatorInsert"].Preped.Exec(c.Guild, c.User, c.Provider, c.Channel, c.ChannelURL, c.LastShared.Unix())
+	return err
+}
+
+// Creators in the guild that shared something since the given time.
+func getCreators(guild string, since time.Time) ([]creator, error) {
+	rows, err := Queries["CreatorList"].Preped.Query(guild, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creators := []creator{}
+	for rows.Next() {
+		c, shared := creator{}, int64(0)
+		err := rows.Scan(&c.Guild, &c.User, &c.Provider, &c.Channel, &c.ChannelURL, &shared)
+		if err != nil {
+			return nil, err
+		}
+		c.LastShared = time.Unix(shared, 0)
+		creators = append(creators, c)
+	}
+	return creators, nil
+}
+
+// Opting out also forgets everything already in the directory.
+func setOptOut(uid string, out bool) error {
+	if !out {
+		_, err := Queries["OptOutRemove"].Preped.Exec(uid)
+		return err
+	}
+	_, err := Queries["OptOutInsert"].Preped.Exec(uid)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["CreatorForget"].Preped.Exec(uid)
+	return err
+}
+
+func isOptedOut(uid string) (bool, error) {
+	n := 0
+	err := Queries["OptOutCount"].Preped.QueryRow(uid).Scan(&n)
+	return n > 0, err
+}
+
+type webhook struct {
+	CID   string
+	ID    string
+	Token string
+}
+
+func addWebhook(wh webhook) error {
+	_, err := Queries["WebhookInsert"].Preped.Exec(wh.CID, wh.ID, wh.Token)
+	return err
+}
+
+func removeWebhook(cid string) error {
+	_, err := Queries["WebhookRemove"].Preped.Exec(cid)
+	return err
+}
+
+// Returns nil if there is no webhook for the channel.
+func getStoredWebhook(cid string) (*webhook, error) {
+	wh := &webhook{}
+	err := Queries["WebhookGet"].Preped.QueryRow(cid).Scan(&wh.CID, &wh.ID, &wh.Token)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+func addRecentLink(url, cid string, t time.Time) error {
+	_, err := Queries["RecentInsert"].Preped.Exec(url, cid, t.Unix())
+	return err
+}
+
+func hasRecentLink(url, cid string, since time.Time) (bool, error) {
+	n := 0
+	err := Queries["RecentCount"].Preped.QueryRow(url, cid, since.Unix()).Scan(&n)
+	return n > 0, err
+}
+
+func addRelayLog(uid, cid string, t time.Time) error {
+	_, err := Queries["LogInsert"].Preped.Exec(uid, cid, t.Unix())
+	return err
+}
+
+func countUserRelays(uid string, since time.Time) (int, error) {
+	n := 0
+	err := Queries["LogCountUser"].Preped.QueryRow(uid, since.Unix()).Scan(&n)
+	return n, err
+}
+
+func countChannelRelays(cid string, since time.Time) (int, error) {
+	n := 0
+	err := Queries["LogCountChannel"].Preped.QueryRow(cid, since.Unix()).Scan(&n)
+	return n, err
+}
+
+// Forgets recent links and relay log entries from before the given time.
+func pruneRecent(before time.Time) error {
+	_, err := Queries["RecentPrune"].Preped.Exec(before.Unix())
+	if err != nil {
+		return err
+	}
+	_, err = Queries["LogPrune"].Preped.Exec(before.Unix())
+	return err
+}
+
+type streamer struct {
+	ID       int64
+	User     string
+	Guild    string
+	Platform string
+	Channel  string
+
+	// The platform's ID for the stream we last announced, empty if not live.
+	LiveID string
+}
+
+// Replaces any existing link for the same user, guild, and platform.
+func addStreamer(st streamer) error {
+	_, err := Queries["StreamerRemove"].Preped.Exec(st.User, st.Guild, st.Platform)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["StreamerInsert"].Preped.Exec(st.User, st.Guild, st.Platform, st.Channel)
+	return err
+}
+
+func removeStreamer(uid, guild, platform string) (bool, error) {
+	res, err := Queries["StreamerRemove"].Preped.Exec(uid, guild, platform)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func getStreamers(platform string) ([]streamer, error) {
+	return queryStreamers(Queries["StreamerList"].Preped.Query(platform))
+}
+
+func getUserStreamers(uid, guild string) ([]streamer, error) {
+	return queryStreamers(Queries["StreamerByUser"].Preped.Query(uid, guild))
+}
+
+func queryStreamers(rows *sql.Rows, err error) ([]streamer, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streamers := []streamer{}
+	for rows.Next() {
+		st := streamer{}
+		err := rows.Scan(&st.ID, &st.User, &st.Guild, &st.Platform, &st.Channel, &st.LiveID)
+		if err != nil {
+			return nil, err
+		}
+		streamers = append(streamers, st)
+	}
+	return streamers, nil
+}
+
+func setStreamerLive(id int64, live string) error {
+	_, err := Queries["StreamerSetLive"].Preped.Exec(live, id)
+	return err
+}
+
+// Marks the streamer offline and forgets the announcements.
+func clearStreamerLive(id int64) error {
+	_, err := Queries["StreamerSetLive"].Preped.Exec("", id)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["StreamerDropLive"].Preped.Exec(id)
+	return err
+}
+
+func addLiveAnnouncement(id int64, cid, mid string) error {
+	_, err := Queries["LiveInsert"].Preped.Exec(id, cid, mid)
+	return err
+}
+
+type message struct {
+	CID string
+	MID string
+
+	// Set if the message was sent through one of our webhooks.
+	Webhook string
+}
+
+func getLiveAnnouncements(id int64) ([]message, error) {
+	rows, err := Queries["LiveList"].Preped.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []message{}
+	for rows.Next() {
+		f := message{}
+		err := rows.Scan(&f.CID, &f.MID)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, f)
+	}
+	return messages, nil
+}
+
+func openDB() {
+	var err error
+	DB, err = sql.Open("sqlite3", DBPath)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = DB.Exec(InitCode)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, m := range Migrations {
+		_, err := DB.Exec(m)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			panic(err)
+		}
+	}
+
+	for _, v := range Queries {
+		err := v.Init()
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+type queryHolder struct {
+	Code   string
+	Preped *sql.Stmt
+}
+
+func (q *queryHolder) Init() error {
+	var err error
+	q.Preped, err = DB.Prepare(q.Code)
+	return err
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/url"
+import "strings"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+var (
+	// A link relayed to a channel won't be relayed there again for this long. Zero disables the check.
+	DuplicateWindow = 24 * time.Hour
+
+	// At most this many relays per user (across all destinations) or per destination channel in RateWindow.
+	// Zero disables the limit.
+	UserRateLimit    = 3
+	ChannelRateLimit = 20
+	RateWindow       = 1 * time.Hour
+
+	// DM the author when their post is skipped.
+	SkipNotices = true
+)
+
+// Query parameters that don't change what a link points at.
+var trackingParams = map[string]bool{
+	"si":      true,
+	"feature": true,
+	"fbclid":  true,
+	"gclid":   true,
+	"igshid":  true,
+	"ref":     true,
+	"pp":      true,
+}
+
+// Normalizes a link so the same thing shared different ways compares equal.
+func canonicalURL(l Link) string {
+	u := *l.URL
+	u.Scheme = "https"
+	u.Fragment = ""
+	u.User = nil
+	u.Host = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."), "m.")
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	q := url.Values{}
+	for k, v := range u.Query() {
+		if trackingParams[k] || strings.HasPrefix(k, "utm_") {
+			continue
+		}
+		q[k] = v
+	}
+
+	// Every way of linking a YouTube video ends up as watch?v=ID, the timestamp (t) and the rest of the query go.
+	if u.Host == "youtu.be" {
+		q.Set("v", strings.TrimPrefix(u.Path, "/"))
+		u.Host, u.Path = "youtube.com", "/watch"
+	}
+	if u.Host == "youtube.com" {
+		for _, prefix := range []string{"/shorts/", "/live/"} {
+			if strings.HasPrefix(u.Path, prefix) {
+				q.Set("v", strings.TrimPrefix(u.Path, prefix))
+				u.Path = "/watch"
+			}
+		}
+	}
+	if u.Host == "youtube.com" && u.Path == "/watch" {
+		q = url.Values{"v": {q.Get("v")}}
+	}
+	u.RawQuery = q.Encode() // Encode sorts by key.
+	return u.String()
+}
+
+// Drops links that were relayed to the rule's destination recently.
+func dropDuplicates(rule relayRule, links []Link, now time.Time) ([]Link, error) {
+	if DuplicateWindow == 0 {
+		return links, nil
+	}
+
+	out := []Link{}
+	for _, link := range links {
+		seen, err := hasRecentLink(canonicalURL(link), rule.ToChannel, now.Add(-DuplicateWindow))
+		if err != nil {
+			return nil, err
+		}
+		if !seen {
+			out = append(out, link)
+		}
+	}
+	return out, nil
+}
+
+// Returns a reason if the user or destination channel is over the limit, or "" if the relay may go ahead.
+func checkRateLimit(rule relayRule, uid string, now time.Time) (string, error) {
+	since := now.Add(-RateWindow)
+	if UserRateLimit > 0 {
+		n, err := countUserRelays(uid, since)
+		if err != nil {
+			return "", err
+		}
+		if n >= UserRateLimit {
+			return fmt.Sprintf("you can only have %d posts relayed every %v", UserRateLimit, RateWindow), nil
+		}
+	}
+	if ChannelRateLimit > 0 {
+		n, err := countChannelRelays(rule.ToChannel, since)
+		if err != nil {
+			return "", err
+		}
+		if n >= ChannelRateLimit {
+			return fmt.Sprintf("<#%s> is getting a lot of posts right now", rule.ToChannel), nil
+		}
+	}
+	return "", nil
+}
+
+// Remembers a relay that was sent for the duplicate check and the creator directory.
+func recordRelay(rule relayRule, uid string, links []Link, now time.Time) {
+	for _, link := range links {
+		err := addRecentLink(canonicalURL(link), rule.ToChannel, now)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+	}
+	recordCreator(rule.Guild, uid, links, now)
+}
+
+// Counts a relay (sent or queued for a moderator) toward the rate limits.
+func logRelay(rule relayRule, uid string, now time.Time) {
+	err := addRelayLog(uid, rule.ToChannel, now)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+
+	// Nothing older than this is ever looked at.
+	keep := DuplicateWindow
+	if RateWindow > keep {
+		keep = RateWindow
+	}
+	err = pruneRecent(now.Add(-keep))
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+}
+
+// Tells the author why their post wasn't relayed. Discord only allows ephemeral messages as interaction
+// responses, so this has to be a DM.
+func skipNotice(s *discordgo.Session, m *discordgo.Message, reason string) {
+	if !SkipNotices {
+		return
+	}
+
+	ch, err := s.UserChannelCreate(m.Author.ID)
+	if err != nil {
+		fmt.Println("Error creating DM channel:", err)
+		return
+	}
+	_, err = s.ChannelMessageSend(ch.ID, "Your post in <#"+m.ChannelID+"> wasn't relayed: "+reason+".")
+	if err != nil {
+		fmt.Println("Error sending message to:", ch.ID, err)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/url"
+import "strings"
+import "regexp"
+
+import "github.com/bwmarrin/discordgo"
+
+// What sort of thing a link points at.
+type Kind string
+
+const (
+	KindVideo  Kind = "video"
+	KindStream Kind = "stream"
+	KindClip   Kind = "clip"
+)
+
+var Kinds = []Kind{KindVideo, KindStream, KindClip}
+
+// A Provider is a site we know how to recognize links for.
+type Provider struct {
+	Name  string
+	Hosts []string // Lower case, without any "www." prefix.
+	Paths []PathPattern
+
+	// oEmbed endpoint, without any query. Empty if the provider doesn't have one.
+	OEmbed string
+
+	// Site pages that look like channel names (twitch.tv/directory). Lower case, never classified as streams.
+	Reserved []string
+}
+
+// The first pattern that matches the path of a link decides the link's Kind.
+type PathPattern struct {
+	Path *regexp.Regexp
+	Kind Kind
+
+	// If set, this query parameter must be present (youtube.com/watch?v=...).
+	Query string
+
+	// If set, the pattern only applies to this one of the provider's hosts.
+	Host string
+}
+
+// The provider registry. Order matters, the first provider and pattern to match wins.
+var Providers = []*Provider{
+	{
+		Name:   "youtube",
+		Hosts:  []string{"youtube.com", "m.youtube.com", "youtu.be"},
+		OEmbed: "https://www.youtube.com/oembed",
+		Paths: []PathPattern{
+			{regexp.MustCompile(`^/watch/?$`), KindVideo, "v", ""},
+			{regexp.MustCompile(`^/shorts/[\w-]+/?$`), KindVideo, "", ""},
+			{regexp.MustCompile(`^/live/[\w-]+/?$`), KindStream, "", ""},
+			{regexp.MustCompile(`^/(@[\w.-]+|channel/[\w-]+)/live/?$`), KindStream, "", ""},
+			{regexp.MustCompile(`^/clip/[\w-]+/?$`), KindClip, "", ""},
+			{regexp.MustCompile(`^/[\w-]{11}$`), KindVideo, "", "youtu.be"},
+		},
+	},
+	{
+		Name:  "twitch",
+		Hosts: []string{"twitch.tv", "m.twitch.tv", "clips.twitch.tv"},
+		Paths: []PathPattern{
+			{regexp.MustCompile(`^/videos/\d+/?$`), KindVideo, "", ""},
+			{regexp.MustCompile(`^/\w+/clip/[\w-]+/?$`), KindClip, "", ""},
+			{regexp.MustCompile(`^/[\w-]+/?$`), KindClip, "", "clips.twitch.tv"},
+			{regexp.MustCompile(`^/\w+/?$`), KindStream, "", ""},
+		},
+		Reserved: []string{
+			"directory", "videos", "settings", "downloads", "jobs", "p", "search", "subscriptions", "inventory",
+			"wallet", "drops", "turbo", "prime", "friends", "messages", "payments", "store", "login", "signup",
+			"logout", "following", "bits", "broadcast", "creatorcamp", "dashboard",
+		},
+	},
+	{
+		Name:  "kick",
+		Hosts: []string{"kick.com"},
+		Paths: []PathPattern{
+			{regexp.MustCompile(`^/[\w-]+/videos/[\w-]+/?$`), KindVideo, "", ""},
+			{regexp.MustCompile(`^/[\w-]+/clips/[\w-]+/?$`), KindClip, "", ""},
+			{regexp.MustCompile(`^/[\w-]+/?$`), KindStream, "", ""},
+		},
+		Reserved: []string{
+			"categories", "category", "browse", "following", "search", "dashboard", "settings", "subscriptions",
+			"terms-of-service", "privacy-policy", "community-guidelines", "dmca-policy", "faq", "login", "signup",
+		},
+	},
+	{
+		Name:  "dlive",
+		Hosts: []string{"dlive.tv"},
+		Paths: []PathPattern{
+			{regexp.MustCompile(`^/p/[\w+-]+/?$`), KindVideo, "", ""},
+			{regexp.MustCompile(`^/[\w-]+/?$`), KindStream, "", ""},
+		},
+	},
+}
+
+// A Link is a URL that was recognized by one of the providers.
+type Link struct {
+	URL      *url.URL
+	Provider *Provider
+	Kind     Kind
+}
+
+func (l Link) String() string {
+	return l.URL.String()
+}
+
+func getProvider(name string) *Provider {
+	for _, p := range Providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Is the first path segment one of the provider's own pages?
+func (p *Provider) reserved(path string) bool {
+	seg := strings.ToLower(strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0])
+	for _, r := range p.Reserved {
+		if r == seg {
+			return true
+		}
+	}
+	return false
+}
+
+func isKind(name string) bool {
+	for _, k := range Kinds {
+		if string(k) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the provider and kind for the given URL, or nil if nothing recognizes it.
+func classifyURL(u *url.URL) (*Provider, Kind) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	for _, p := range Providers {
+		for _, h := range p.Hosts {
+			if h != host {
+				continue
+			}
+			for _, pat := range p.Paths {
+				if pat.Host != "" && pat.Host != host {
+					continue
+				}
+				if pat.Kind == KindStream && p.reserved(u.Path) {
+					continue
+				}
+				if pat.Path.MatchString(u.Path) && (pat.Query == "" || u.Query().Get(pat.Query) != "") {
+					return p, pat.Kind
+				}
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Discord doesn't link anything without a scheme, so neither do we. Angle brackets are excluded
+// so that <https://...> (link with the preview suppressed) still works.
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>|]+`)
+
+// Pulls every recognized link out of a message's content and embeds, in order, without duplicates.
+func extractLinks(m *discordgo.Message) []Link {
+	candidates := urlRegexp.FindAllString(m.Content, -1)
+	for _, e := range m.Embeds {
+		if e.URL != "" {
+			candidates = append(candidates, e.URL)
+		}
+	}
+
+	seen := map[string]bool{}
+	links := []Link{}
+	for _, raw := range candidates {
+		// Trailing punctuation is almost always part of the sentence, not the link.
+		raw = strings.TrimRight(raw, ".,!?:;)]}'\"*_~`")
+		if seen[raw] {
+			continue
+		}
+		seen[raw] = true
+
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		p, k := classifyURL(u)
+		if p == nil {
+			continue
+		}
+		links = append(links, Link{URL: u, Provider: p, Kind: k})
+	}
+	return links
+}
+
+// Makes sure every matcher is either a provider name or a kind. Returns the first bad one.
+func validateMatchers(matchers []string) (string, bool) {
+	for _, m := range matchers {
+		if getProvider(m) == nil && !isKind(m) {
+			return m, false
+		}
+	}
+	return "", true
+}
+
+// Matchers are a mix of provider names and kinds. If any providers are listed the link must be from one
+// of them, likewise for kinds. No matchers means anything any provider recognizes.
+func (r relayRule) Matches(l Link) bool {
+	providers, kinds := false, false
+	providerOK, kindOK := false, false
+	for _, m := range r.Matchers {
+		if isKind(m) {
+			kinds = true
+			kindOK = kindOK || Kind(m) == l.Kind
+			continue
+		}
+		providers = true
+		providerOK = providerOK || m == l.Provider.Name
+	}
+	return (!providers || providerOK) && (!kinds || kindOK)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/url"
+import "testing"
+
+import "github.com/bwmarrin/discordgo"
+
+func TestClassifyURL(t *testing.T) {
+	cases := []struct {
+		link     string
+		provider string // Empty if nothing should recognize it.
+		kind     Kind
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube", KindVideo},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ&t=42", "youtube", KindVideo},
+		{"https://youtube.com/watch", "", ""},
+		{"https://youtu.be/dQw4w9WgXcQ", "youtube", KindVideo},
+		{"https://youtube.com/dQw4w9WgXcQ", "", ""},
+		{"https://www.youtube.com/shorts/abc-DEF_123", "youtube", KindVideo},
+		{"https://www.youtube.com/live/abc-DEF_123", "youtube", KindStream},
+		{"https://www.youtube.com/@tyron/live", "youtube", KindStream},
+		{"https://www.youtube.com/channel/UCabc/live", "youtube", KindStream},
+		{"https://www.youtube.com/clip/UgkxAbc", "youtube", KindClip},
+		{"https://www.youtube.com/feed/subscriptions", "", ""},
+		{"HTTPS://WWW.YOUTUBE.COM/watch?v=dQw4w9WgXcQ", "youtube", KindVideo},
+
+		{"https://www.twitch.tv/tyron", "twitch", KindStream},
+		{"https://twitch.tv/tyron/", "twitch", KindStream},
+		{"https://m.twitch.tv/tyron", "twitch", KindStream},
+		{"https://www.twitch.tv/videos/123456", "twitch", KindVideo},
+		{"https://www.twitch.tv/tyron/clip/FunnyClip-abc", "twitch", KindClip},
+		{"https://clips.twitch.tv/FunnyClip-abc", "twitch", KindClip},
+		{"https://www.twitch.tv/directory", "", ""},
+		{"https://www.twitch.tv/Directory/", "", ""},
+		{"https://www.twitch.tv/directory/game/Vintage%20Story", "", ""},
+		{"https://www.twitch.tv/settings", "", ""},
+		{"https://www.twitch.tv/videos", "", ""},
+		{"https://www.twitch.tv/search?term=vintage", "", ""},
+
+		{"https://kick.com/tyron", "kick", KindStream},
+		{"https://kick.com/tyron/videos/abc-123", "kick", KindVideo},
+		{"https://kick.com/tyron/clips/clip_abc", "kick", KindClip},
+		{"https://kick.com/categories", "", ""},
+		{"https://kick.com/browse", "", ""},
+		{"https://kick.com/following", "", ""},
+
+		{"https://dlive.tv/tyron", "dlive", KindStream},
+		{"https://dlive.tv/p/tyron+abc-123", "dlive", KindVideo},
+
+		{"ftp://www.twitch.tv/tyron", "", ""},
+		{"https://example.com/tyron", "", ""},
+		{"https://nottwitch.tv/tyron", "", ""},
+	}
+
+	for _, c := range cases {
+		u, err := url.Parse(c.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, k := classifyURL(u)
+		name := ""
+		if p != nil {
+			name = p.Name
+		}
+		if name != c.provider || k != c.kind {
+			t.Errorf("%v: got %q %q, want %q %q", c.link, name, k, c.provider, c.kind)
+		}
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		embeds  []string
+		want    []string
+	}{
+		{"nothing", "no links here", nil, []string{}},
+		{"plain", "live now https://twitch.tv/tyron", nil, []string{"https://twitch.tv/tyron"}},
+		{"no scheme", "live now twitch.tv/tyron", nil, []string{}},
+		{"trailing punctuation", "watch this (https://youtu.be/dQw4w9WgXcQ)!", nil, []string{"https://youtu.be/dQw4w9WgXcQ"}},
+		{"suppressed preview", "<https://youtu.be/dQw4w9WgXcQ>", nil, []string{"https://youtu.be/dQw4w9WgXcQ"}},
+		{"markdown", "**https://kick.com/tyron**", nil, []string{"https://kick.com/tyron"}},
+		{"unrecognized dropped", "https://example.com/a https://kick.com/tyron https://twitch.tv/directory", nil, []string{"https://kick.com/tyron"}},
+		{"duplicates", "https://kick.com/tyron and again https://kick.com/tyron.", nil, []string{"https://kick.com/tyron"}},
+		{"in order", "https://kick.com/b https://twitch.tv/a", nil, []string{"https://kick.com/b", "https://twitch.tv/a"}},
+		{
+			"embeds after content",
+			"https://twitch.tv/tyron",
+			[]string{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://twitch.tv/tyron", ""},
+			[]string{"https://twitch.tv/tyron", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		},
+	}
+
+	for _, c := range cases {
+		m := &discordgo.Message{Content: c.content}
+		for _, e := range c.embeds {
+			m.Embeds = append(m.Embeds, &discordgo.MessageEmbed{URL: e})
+		}
+
+		links := extractLinks(m)
+		got := []string{}
+		for _, l := range links {
+			got = append(got, l.String())
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,490 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "encoding/json"
+import "encoding/xml"
+import "net/http"
+import "net/url"
+import "strings"
+import "errors"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+var (
+	// Only streams of this game get announced.
+	LiveGame = "Vintage Story"
+
+	LivePollTime = 2 * time.Minute
+
+	TwitchClientID string
+	TwitchToken    string
+	YouTubeKey     string
+)
+
+// Keyed by provider name. Platforms without credentials are left out, so nothing is polled for them.
+var LiveAPIs = map[string]LiveAPI{}
+
+// LiveAPI is something that can tell if a set of channels on one platform are live.
+type LiveAPI interface {
+	// Returns the live streams for the given channels, keyed by channel. Channels that are not live are left out,
+	// channels that couldn't be checked map to nil so they aren't taken as offline. One bad channel must not stop
+	// the rest being checked, the error is for when nothing could be.
+	Live(channels []string) (map[string]*LiveStream, error)
+}
+
+type LiveStream struct {
+	ID        string
+	Title     string
+	Game      string // May be empty if the platform doesn't say.
+	URL       string
+	Thumbnail string
+	Started   time.Time
+}
+
+// Does this stream count as playing LiveGame?
+func (l *LiveStream) Playing() bool {
+	if l.Game != "" {
+		return strings.EqualFold(l.Game, LiveGame)
+	}
+	return strings.Contains(strings.ToLower(l.Title), strings.ToLower(LiveGame))
+}
+
+func init() {
+	if TwitchClientID != "" && TwitchToken != "" {
+		LiveAPIs["twitch"] = &TwitchAPI{ClientID: TwitchClientID, Token: TwitchToken}
+	}
+	if YouTubeKey != "" {
+		LiveAPIs["youtube"] = &YouTubeAPI{Key: YouTubeKey}
+	}
+}
+
+// TwitchAPI uses the Helix streams endpoint. Channels are user logins.
+type TwitchAPI struct {
+	ClientID string
+	Token    string
+
+	Base   string // Defaults to the real API.
+	Client *http.Client
+}
+
+func (t *TwitchAPI) Live(channels []string) (map[string]*LiveStream, error) {
+	out := map[string]*LiveStream{}
+
+	// Helix takes at most 100 logins per request.
+	for len(channels) > 0 {
+		batch := channels
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		channels = channels[len(batch):]
+
+		err := t.streams(batch, out)
+		if err == nil || len(batch) == 1 {
+			if err != nil {
+				fmt.Println("Live API Error: twitch", batch[0], err)
+				out[batch[0]] = nil
+			}
+			continue
+		}
+
+		// Something in the batch upset it, find out what so the rest still get checked.
+		for _, c := range batch {
+			err := t.streams([]string{c}, out)
+			if err != nil {
+				fmt.Println("Live API Error: twitch", c, err)
+				out[c] = nil
+			}
+		}
+	}
+	return out, nil
+}
+
+// Adds the live streams for up to 100 logins to out.
+func (t *TwitchAPI) streams(logins []string, out map[string]*LiveStream) error {
+	q := url.Values{}
+	for _, c := range logins {
+		q.Add("user_login", c)
+	}
+	req, err := http.NewRequest("GET", orDefault(t.Base, "https://api.twitch.tv/helix")+"/streams?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Client-Id", t.ClientID)
+	req.Header.Set("Authorization", "Bearer "+t.Token)
+
+	data := struct {
+		Data []struct {
+			ID        string    `json:"id"`
+			Login     string    `json:"user_login"`
+			Game      string    `json:"game_name"`
+			Type      string    `json:"type"`
+			Title     string    `json:"title"`
+			Started   time.Time `json:"started_at"`
+			Thumbnail string    `json:"thumbnail_url"`
+		} `json:"data"`
+	}{}
+	err = getJSON(t.Client, req, &data)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range data.Data {
+		if s.Type != "live" {
+			continue
+		}
+		out[strings.ToLower(s.Login)] = &LiveStream{
+			ID:        s.ID,
+			Title:     s.Title,
+			Game:      s.Game,
+			URL:       "https://www.twitch.tv/" + s.Login,
+			Thumbnail: strings.NewReplacer("{width}", "640", "{height}", "360").Replace(s.Thumbnail),
+			Started:   s.Started,
+		}
+	}
+	return nil
+}
+
+// YouTubeAPI reads each channel's public uploads feed for its newest videos, then asks the Data API which of them
+// are live. Channels are channel IDs.
+//
+// The search endpoint can filter on live streams directly, but it costs 100 quota units a call, where the feed is
+// free and videos.list costs 1 unit for up to 50 videos.
+//
+// YouTube doesn't report what game is being played, so the title has to mention it.
+type YouTubeAPI struct {
+	Key string
+
+	Base     string // Defaults to the real API.
+	FeedBase string // Defaults to the real feed.
+	Client   *http.Client
+}
+
+// How many of the newest uploads to check per channel. A stream shows up in the feed as soon as it is scheduled or
+// started, so it will be near the top.
+const youTubeFeedDepth = 5
+
+func (y *YouTubeAPI) Live(channels []string) (map[string]*LiveStream, error) {
+	out := map[string]*LiveStream{}
+
+	videos := []string{}
+	for _, c := range channels {
+		feed := struct {
+			Entries []struct {
+				VideoID string `xml:"videoId"`
+			} `xml:"entry"`
+		}{}
+		req, err := http.NewRequest("GET", orDefault(y.FeedBase, "https://www.youtube.com/feeds/videos.xml")+"?channel_id="+url.QueryEscape(c), nil)
+		if err == nil {
+			err = getXML(y.Client, req, &feed)
+		}
+		if err != nil {
+			// Most likely a channel that doesn't exist, that shouldn't stop everyone else's announcements.
+			fmt.Println("Live API Error: youtube", c, err)
+			out[c] = nil
+			continue
+		}
+
+		for i, e := range feed.Entries {
+			if i >= youTubeFeedDepth {
+				break
+			}
+			videos = append(videos, e.VideoID)
+		}
+	}
+
+	// videos.list takes at most 50 IDs per request.
+	for len(videos) > 0 {
+		batch := videos
+		if len(batch) > 50 {
+			batch = batch[:50]
+		}
+		videos = videos[len(batch):]
+
+		q := url.Values{}
+		q.Set("part", "snippet,liveStreamingDetails")
+		q.Set("id", strings.Join(batch, ","))
+		q.Set("key", y.Key)
+		req, err := http.NewRequest("GET", orDefault(y.Base, "https://www.googleapis.com/youtube/v3")+"/videos?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		data := struct {
+			Items []struct {
+				ID      string `json:"id"`
+				Snippet struct {
+					ChannelID  string `json:"channelId"`
+					Title      string `json:"title"`
+					Thumbnails map[string]struct {
+						URL string `json:"url"`
+					} `json:"thumbnails"`
+				} `json:"snippet"`
+				Details *struct {
+					Started time.Time `json:"actualStartTime"`
+					Ended   time.Time `json:"actualEndTime"`
+				} `json:"liveStreamingDetails"`
+			} `json:"items"`
+		}{}
+		err = getJSON(y.Client, req, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range data.Items {
+			if item.Details == nil || item.Details.Started.IsZero() || !item.Details.Ended.IsZero() {
+				continue
+			}
+			out[item.Snippet.ChannelID] = &LiveStream{
+				ID:        item.ID,
+				Title:     item.Snippet.Title,
+				URL:       "https://www.youtube.com/watch?v=" + item.ID,
+				Thumbnail: item.Snippet.Thumbnails["high"].URL,
+				Started:   item.Details.Started,
+			}
+		}
+	}
+	return out, nil
+}
+
+// FakeLiveAPI is a stand in for the real platforms, set Streams to whatever should be live.
+type FakeLiveAPI struct {
+	Streams map[string]*LiveStream
+}
+
+func (f *FakeLiveAPI) Live(channels []string) (map[string]*LiveStream, error) {
+	out := map[string]*LiveStream{}
+	for _, c := range channels {
+		if s, ok := f.Streams[c]; ok {
+			out[c] = s
+		}
+	}
+	return out, nil
+}
+
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return errors.New("unexpected status: " + r.Status)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func getXML(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return errors.New("unexpected status: " + r.Status)
+	}
+	return xml.NewDecoder(r.Body).Decode(v)
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func pollLive(s *discordgo.Session) {
+	for {
+		for platform, api := range LiveAPIs {
+			checkLive(s, platform, api)
+		}
+		time.Sleep(LivePollTime)
+	}
+}
+
+func checkLive(s *discordgo.Session, platform string, api LiveAPI) {
+	streamers, err := getStreamers(platform)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if len(streamers) == 0 {
+		return
+	}
+
+	live, err := playingStreams(api, streamers)
+	if err != nil {
+		fmt.Println("Live API Error:", platform, err)
+		return
+	}
+
+	for _, st := range streamers {
+		stream, ok := live[st.Channel]
+		if ok && stream == nil {
+			continue // Couldn't check this time, leave it as it was.
+		}
+		switch {
+		case stream != nil && stream.ID != st.LiveID:
+			if st.LiveID != "" {
+				endLive(s, st)
+			}
+			announceLive(s, st, stream)
+		case stream == nil && st.LiveID != "":
+			endLive(s, st)
+		}
+	}
+}
+
+// Returns the streams of LiveGame going on right now, keyed by channel, with nil for channels that couldn't be checked. Each channel is only asked about once,
+// however many people linked it.
+func playingStreams(api LiveAPI, streamers []streamer) (map[string]*LiveStream, error) {
+	channels, seen := []string{}, map[string]bool{}
+	for _, st := range streamers {
+		if !seen[st.Channel] {
+			seen[st.Channel] = true
+			channels = append(channels, st.Channel)
+		}
+	}
+
+	live, err := api.Live(channels)
+	if err != nil {
+		return nil, err
+	}
+	for c, stream := range live {
+		if stream != nil && !stream.Playing() {
+			delete(live, c)
+		}
+	}
+	return live, nil
+}
+
+func announceLive(s *discordgo.Session, st streamer, stream *LiveStream) {
+	err := setStreamerLive(st.ID, stream.ID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       stream.Title,
+		URL:         stream.URL,
+		Description: "<@" + st.User + "> is live with " + LiveGame + "!",
+		Color:       0x00ff00,
+		Timestamp:   stream.Started.Format(time.RFC3339),
+	}
+	if stream.Thumbnail != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: stream.Thumbnail}
+	}
+
+	for _, cid := range liveChannels(st) {
+		mdat, err := s.ChannelMessageSendEmbed(cid, embed)
+		if err != nil {
+			fmt.Println("Error sending message to:", cid, err)
+			continue
+		}
+		err = addLiveAnnouncement(st.ID, cid, mdat.ID)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+	}
+}
+
+func endLive(s *discordgo.Session, st streamer) {
+	announcements, err := getLiveAnnouncements(st.ID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	for _, a := range announcements {
+		m, err := s.ChannelMessage(a.CID, a.MID)
+		if err != nil {
+			fmt.Println("Error reading old message:", err)
+			continue
+		}
+		if len(m.Embeds) == 0 {
+			fmt.Println("Message with no embed:", a.MID)
+			continue
+		}
+
+		embed := m.Embeds[0]
+		embed.Color = 0x808080
+		embed.Description = "<@" + st.User + "> was live with " + LiveGame + ". The stream has ended."
+		embed.Image = nil
+		if started, err := time.Parse(time.RFC3339, embed.Timestamp); err == nil {
+			embed.Footer = &discordgo.MessageEmbedFooter{Text: "Streamed for " + time.Since(started).Round(time.Minute).String()}
+		}
+
+		_, err = s.ChannelMessageEditEmbed(a.CID, a.MID, embed)
+		if err != nil {
+			fmt.Println("Error editing message to:", a.MID, err)
+		}
+	}
+
+	err = clearStreamerLive(st.ID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+}
+
+// Marks any live post for a user's link as ended and forgets it, for when the link is about to be removed or replaced.
+func endStreamerLink(s *discordgo.Session, uid, guild, platform string) {
+	streamers, err := getUserStreamers(uid, guild)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	for _, st := range streamers {
+		if st.Platform == platform {
+			endLive(s, st)
+		}
+	}
+}
+
+// Announcements go to every destination channel in the streamer's guild with a rule that would relay the stream link.
+func liveChannels(st streamer) []string {
+	rules, err := getRules(st.Guild)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return nil
+	}
+
+	link := Link{Provider: getProvider(st.Platform), Kind: KindStream}
+	channels, seen := []string{}, map[string]bool{}
+	for _, rule := range rules {
+		if link.Provider == nil || !rule.Matches(link) || seen[rule.ToChannel] {
+			continue
+		}
+		seen[rule.ToChannel] = true
+		channels = append(channels, rule.ToChannel)
+	}
+	return channels
+}
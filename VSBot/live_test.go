@@ -0,0 +1,205 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/http/httptest"
+import "net/http"
+import "strings"
+import "testing"
+import "time"
+
+// Wraps an API to record what it was asked.
+type recordingLiveAPI struct {
+	LiveAPI
+	asked [][]string
+}
+
+func (r *recordingLiveAPI) Live(channels []string) (map[string]*LiveStream, error) {
+	r.asked = append(r.asked, channels)
+	return r.LiveAPI.Live(channels)
+}
+
+func TestPlayingStreams(t *testing.T) {
+	api := &recordingLiveAPI{LiveAPI: &FakeLiveAPI{Streams: map[string]*LiveStream{
+		"tyron":   {ID: "1", Title: "Building stuff", Game: "Vintage Story"},
+		"saraty":  {ID: "2", Title: "Vintage Story, day 40", Game: "Something Else"},
+		"youtube": {ID: "3", Title: "Surviving winter in vintage story"},
+		"other":   {ID: "4", Title: "Just chatting"},
+		"broken":  nil, // Couldn't be checked.
+	}}}
+	streamers := []streamer{
+		{ID: 1, Channel: "tyron"},
+		{ID: 2, Channel: "tyron"}, // Linked in two guilds.
+		{ID: 3, Channel: "saraty"},
+		{ID: 4, Channel: "youtube"},
+		{ID: 5, Channel: "other"},
+		{ID: 6, Channel: "offline"},
+		{ID: 7, Channel: "broken"},
+	}
+
+	live, err := playingStreams(api, streamers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.asked) != 1 || strings.Join(api.asked[0], ",") != "tyron,saraty,youtube,other,offline,broken" {
+		t.Errorf("asked about the wrong channels: %v", api.asked)
+	}
+	if broken, ok := live["broken"]; !ok || broken != nil {
+		t.Errorf("an unchecked channel should stay unknown, got %v", broken)
+	}
+	if len(live) != 3 || live["tyron"] == nil || live["youtube"] == nil {
+		t.Errorf("wrong streams: %v", live)
+	}
+}
+
+func TestYouTubeAPI(t *testing.T) {
+	feeds := map[string]string{
+		"UCaaaaaaaaaaaaaaaaaaaaaa": `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">
+	<entry><yt:videoId>live1</yt:videoId></entry>
+	<entry><yt:videoId>old1</yt:videoId></entry>
+</feed>`,
+		"UCbbbbbbbbbbbbbbbbbbbbbb": `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">
+	<entry><yt:videoId>soon2</yt:videoId></entry>
+	<entry><yt:videoId>ended2</yt:videoId></entry>
+</feed>`,
+	}
+
+	videos := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feeds":
+			feed, ok := feeds[r.URL.Query().Get("channel_id")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(feed))
+		case "/api/videos":
+			if r.URL.Query().Get("key") != "secret" || r.URL.Query().Get("part") != "snippet,liveStreamingDetails" {
+				t.Errorf("bad videos request: %v", r.URL)
+			}
+			videos = append(videos, r.URL.Query().Get("id"))
+			w.Write([]byte(`{"items": [
+				{"id": "live1", "snippet": {"channelId": "UCaaaaaaaaaaaaaaaaaaaaaa", "title": "Vintage Story stream",
+					"thumbnails": {"high": {"url": "https://example.com/live1.jpg"}}},
+					"liveStreamingDetails": {"actualStartTime": "2024-05-01T18:00:00Z"}},
+				{"id": "old1", "snippet": {"channelId": "UCaaaaaaaaaaaaaaaaaaaaaa", "title": "An upload"}},
+				{"id": "soon2", "snippet": {"channelId": "UCbbbbbbbbbbbbbbbbbbbbbb", "title": "Upcoming"},
+					"liveStreamingDetails": {"scheduledStartTime": "2024-05-02T18:00:00Z"}},
+				{"id": "ended2", "snippet": {"channelId": "UCbbbbbbbbbbbbbbbbbbbbbb", "title": "Last week"},
+					"liveStreamingDetails": {"actualStartTime": "2024-04-24T18:00:00Z", "actualEndTime": "2024-04-24T20:00:00Z"}}
+			]}`))
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	api := &YouTubeAPI{Key: "secret", Base: srv.URL + "/api", FeedBase: srv.URL + "/feeds"}
+	// The middle channel doesn't exist, that mustn't stop the others being checked.
+	live, err := api.Live([]string{"UCaaaaaaaaaaaaaaaaaaaaaa", "UCcccccccccccccccccccccc", "UCbbbbbbbbbbbbbbbbbbbbbb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every recent video goes in one videos.list call, that is the only part that costs quota.
+	if len(videos) != 1 || videos[0] != "live1,old1,soon2,ended2" {
+		t.Errorf("wrong videos requests: %v", videos)
+	}
+	if missing, ok := live["UCcccccccccccccccccccccc"]; !ok || missing != nil {
+		t.Errorf("the missing channel should be reported as unknown, got %v", missing)
+	}
+	if len(live) != 2 {
+		t.Fatalf("expected 1 live stream and 1 unknown channel, got %v", live)
+	}
+	stream := live["UCaaaaaaaaaaaaaaaaaaaaaa"]
+	if stream == nil || stream.ID != "live1" || stream.URL != "https://www.youtube.com/watch?v=live1" || stream.Thumbnail != "https://example.com/live1.jpg" {
+		t.Errorf("wrong stream: %+v", stream)
+	}
+	if stream != nil && !stream.Started.Equal(time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong start time: %v", stream.Started)
+	}
+}
+
+func TestTwitchAPI(t *testing.T) {
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Client-Id") != "client" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("missing credentials: %v", r.Header)
+		}
+		logins := r.URL.Query()["user_login"]
+		requests = append(requests, strings.Join(logins, ","))
+
+		// Like Helix, one bad login fails the whole request.
+		streams := []string{}
+		for _, login := range logins {
+			switch login {
+			case "ghost":
+				http.Error(w, `{"error": "Bad Request"}`, http.StatusBadRequest)
+				return
+			case "tyron":
+				streams = append(streams, `{"id": "9", "user_login": "Tyron", "game_name": "Vintage Story", "type": "live", "title": "Building",
+					"started_at": "2024-05-01T18:00:00Z", "thumbnail_url": "https://example.com/{width}x{height}.jpg"}`)
+			case "saraty":
+				streams = append(streams, `{"id": "10", "user_login": "saraty", "type": "", "title": "Gone"}`)
+			}
+		}
+		w.Write([]byte(`{"data": [` + strings.Join(streams, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	api := &TwitchAPI{ClientID: "client", Token: "token", Base: srv.URL}
+	live, err := api.Live([]string{"tyron", "saraty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0] != "tyron,saraty" {
+		t.Errorf("wrong requests: %v", requests)
+	}
+	if len(live) != 1 {
+		t.Fatalf("expected 1 live stream, got %v", live)
+	}
+	stream := live["tyron"]
+	if stream == nil || stream.URL != "https://www.twitch.tv/Tyron" || stream.Thumbnail != "https://example.com/640x360.jpg" || !stream.Playing() {
+		t.Errorf("wrong stream: %+v", stream)
+	}
+
+	// A failed batch is retried a login at a time, so only the bad login goes unchecked.
+	requests = nil
+	live, err = api.Live([]string{"tyron", "ghost", "saraty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(requests, " ") != "tyron,ghost,saraty tyron ghost saraty" {
+		t.Errorf("wrong requests: %v", requests)
+	}
+	if ghost, ok := live["ghost"]; !ok || ghost != nil {
+		t.Errorf("the bad login should be reported as unknown, got %v", ghost)
+	}
+	if len(live) != 2 || live["tyron"] == nil {
+		t.Errorf("wrong streams: %v", live)
+	}
+}
@@ -30,15 +30,19 @@ import "os"
 
 import "github.com/bwmarrin/discordgo"
 
-// https://discordapp.com/oauth2/authorize?client_id=485596564455424003&scope=bot&permissions=2048
+// https://discordapp.com/oauth2/authorize?client_id=485596564455424003&scope=bot&permissions=536955904
+// View Channels, Send Messages, Embed Links, Read Message History and Manage Webhooks.
 var (
 	APIKey string
 
+	// Only used to seed the first relay rule when the DB is empty, after that use the `!relay` commands.
 	FromChannel = "418404936275984405"
 	ToChannel   = "484635648712638475"
 )
 
 func main() {
+	openDB()
+
 	// Spin up the server.
 
 	// Create a new Discord session using the provided bot token.
@@ -49,6 +53,10 @@ func main() {
 	}
 
 	dg.AddHandler(messageCreate)
+	dg.AddHandler(messageUpdate)
+	dg.AddHandler(messageDelete)
+	dg.AddHandler(messageDeleteBulk)
+	dg.AddHandler(interactionCreate)
 	dg.AddHandler(onConnect)
 
 	err = dg.Open()
@@ -57,34 +65,146 @@ func main() {
 		return
 	}
 
-	exitSignal := make(chan os.Signal)
+	go pollLive(dg)
+	go pollModQueue(dg)
+	go pollModDB(dg)
+	go pollServers(dg)
+
+	exitSignal := make(chan os.Signal, 1)
 	signal.Notify(exitSignal, os.Interrupt, os.Kill)
 	<-exitSignal
 	dg.Close()
 }
 
-var prefixes = []string{
-	"youtube.com/watch",
-	"youtu.be/",
-	"twitch.tv/",
-	"dlive.tv/",
-	"mixer.com/",
+func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.ID == s.State.User.ID {
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!relay") {
+		relayCommand(s, m)
+		return
+	}
+	// Has to come before !stream.
+	if strings.HasPrefix(m.Content, "!creators") || strings.HasPrefix(m.Content, "!streams") {
+		creatorsCommand(s, m)
+		return
+	}
+	if strings.HasPrefix(m.Content, "!stream") {
+		streamCommand(s, m)
+		return
+	}
+	if strings.HasPrefix(m.Content, "!mods") {
+		modsCommand(s, m)
+		return
+	}
+	if m.Content == "!servers" {
+		_, err := s.ChannelMessageSendEmbed(m.ChannelID, serverEmbed())
+		if err != nil {
+			fmt.Println("Error sending message to:", m.ChannelID, err)
+		}
+		return
+	}
+
+	syncRelays(s, m.Message, true)
 }
 
-func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.ChannelID != FromChannel {
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	msg := m.Message
+
+	rules, err := getRulesFrom(msg.ChannelID)
+	if err != nil || len(rules) == 0 {
 		return
 	}
 
-	for _, prefix := range prefixes {
-		if strings.Contains(m.Content, prefix) {
-			s.ChannelMessageSend(ToChannel, "<@"+m.Author.ID+">: "+m.Content)
+	// Updates that only add embeds don't include the author or content.
+	if msg.Author == nil {
+		full, err := s.ChannelMessage(msg.ChannelID, msg.ID)
+		if err != nil {
+			fmt.Println("Error reading updated message:", err)
 			return
 		}
+		if full.GuildID == "" {
+			full.GuildID = msg.GuildID
+		}
+		msg = full
+	}
+
+	if msg.Author.ID == s.State.User.ID {
+		return
+	}
+
+	syncRelays(s, msg, false)
+}
+
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	deleteRelays(s, m.ID)
+}
+
+func messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	for _, id := range m.Messages {
+		deleteRelays(s, id)
+	}
+}
+
+func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		modButton(s, i)
+	case discordgo.InteractionModalSubmit:
+		modModal(s, i)
 	}
 }
 
 func onConnect(s *discordgo.Session, r *discordgo.Ready) {
+	seedRules(s)
+
 	// Discard the error, it doesn't hurt anything if this fails.
-	_ = s.UpdateStatus(-1, "Vintage Story")
+	_ = s.UpdateGameStatus(0, "Vintage Story")
+}
+
+// The first time the bot runs with no rules at all, create one from the old hardcoded channels. This only
+// ever happens once, so removing that rule later sticks.
+func seedRules(s *discordgo.Session) {
+	if FromChannel == "" || ToChannel == "" {
+		return
+	}
+
+	seeded, err := hasFlag("SeededRules")
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if seeded {
+		return
+	}
+
+	n, err := countRules()
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if n != 0 {
+		// Seeded before there was a flag for it.
+		err := setFlag("SeededRules")
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+		return
+	}
+
+	ch, err := s.Channel(FromChannel)
+	if err != nil {
+		fmt.Println("Error reading seed channel:", err)
+		return
+	}
+	_, err = addRule(relayRule{Guild: ch.GuildID, FromChannel: FromChannel, ToChannel: ToChannel})
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	err = setFlag("SeededRules")
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
 }
@@ -0,0 +1,207 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/http"
+import "net/url"
+import "strings"
+import "regexp"
+import "errors"
+import "time"
+import "html"
+import "fmt"
+import "unicode/utf8"
+
+import "github.com/bwmarrin/discordgo"
+
+// Point these somewhere local to test against a fixture server.
+var (
+	ModDBBase   = "https://mods.vintagestory.at"
+	ModDBClient = &http.Client{Timeout: 30 * time.Second}
+
+	ModDBPollTime = 15 * time.Minute
+)
+
+type ModDBMod struct {
+	ModID    int64          `json:"modid"`
+	AssetID  int64          `json:"assetid"`
+	Name     string         `json:"name"`
+	URLAlias string         `json:"urlalias"`
+	Logo     string         `json:"logofile"`
+	Releases []ModDBRelease `json:"releases"`
+}
+
+type ModDBRelease struct {
+	ReleaseID int64    `json:"releaseid"`
+	File      string   `json:"mainfile"`
+	Version   string   `json:"modversion"`
+	Tags      []string `json:"tags"` // Game versions.
+	Created   string   `json:"created"`
+	Changelog string   `json:"changelog"` // HTML.
+}
+
+func (m *ModDBMod) PageURL() string {
+	if m.URLAlias != "" {
+		return ModDBBase + "/" + m.URLAlias
+	}
+	return fmt.Sprintf("%v/show/mod/%d", ModDBBase, m.AssetID)
+}
+
+// Looks up a mod by numeric ID or URL alias.
+func getModDBMod(id string) (*ModDBMod, error) {
+	req, err := http.NewRequest("GET", ModDBBase+"/api/mod/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Mod        *ModDBMod `json:"mod"`
+		StatusCode string    `json:"statuscode"`
+	}{}
+	err = getJSON(ModDBClient, req, &data)
+	if err != nil {
+		return nil, err
+	}
+	if data.StatusCode != "200" || data.Mod == nil {
+		return nil, errors.New("no such mod: " + id)
+	}
+	return data.Mod, nil
+}
+
+func pollModDB(s *discordgo.Session) {
+	for {
+		checkModDB(s)
+		time.Sleep(ModDBPollTime)
+	}
+}
+
+func checkModDB(s *discordgo.Session) {
+	watches, err := getModWatches("")
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	byMod := map[int64][]modWatch{}
+	for _, w := range watches {
+		byMod[w.ModID] = append(byMod[w.ModID], w)
+	}
+
+	for id, watchers := range byMod {
+		mod, err := getModDBMod(fmt.Sprint(id))
+		if err != nil {
+			fmt.Println("ModDB Error:", id, err)
+			continue
+		}
+
+		seen, err := getSeenReleases(id)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			continue
+		}
+
+		for _, release := range mod.Releases {
+			if seen[release.ReleaseID] {
+				continue
+			}
+			err := addSeenRelease(id, release.ReleaseID)
+			if err != nil {
+				fmt.Println("DB Error:", err)
+				continue
+			}
+
+			embed := releaseEmbed(mod, release)
+			for _, w := range watchers {
+				_, err := s.ChannelMessageSendEmbed(w.Channel, embed)
+				if err != nil {
+					fmt.Println("Error sending message to:", w.Channel, err)
+				}
+			}
+		}
+	}
+}
+
+// Marks every current release of a mod as seen, so a new watch doesn't announce the whole history.
+func markReleasesSeen(mod *ModDBMod) error {
+	seen, err := getSeenReleases(mod.ModID)
+	if err != nil {
+		return err
+	}
+	for _, release := range mod.Releases {
+		if seen[release.ReleaseID] {
+			continue
+		}
+		err := addSeenRelease(mod.ModID, release.ReleaseID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func releaseEmbed(mod *ModDBMod, release ModDBRelease) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Version:", Value: orDefault(release.Version, "unknown"), Inline: true},
+		{Name: "Game Versions:", Value: orDefault(strings.Join(release.Tags, ", "), "unknown"), Inline: true},
+	}
+	if changelog := excerpt(release.Changelog, 500); changelog != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Changelog:", Value: changelog})
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:  "New release: " + mod.Name,
+		URL:    mod.PageURL(),
+		Color:  0x7f5a3a,
+		Fields: fields,
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", release.Created); err == nil {
+		embed.Timestamp = t.Format(time.RFC3339)
+	}
+	if mod.Logo != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: mod.Logo}
+	}
+	return embed
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</li>`)
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// Turns an HTML changelog into plain text, cut down to at most n bytes.
+func excerpt(in string, n int) string {
+	out := htmlBreaks.ReplaceAllString(in, "\n")
+	out = html.UnescapeString(htmlTags.ReplaceAllString(out, ""))
+	out = strings.TrimSpace(blankLines.ReplaceAllString(out, "\n"))
+	if len(out) <= n {
+		return out
+	}
+
+	// Don't cut a rune in half.
+	cut := n - 3
+	for cut > 0 && !utf8.RuneStart(out[cut]) {
+		cut--
+	}
+	return out[:cut] + "..."
+}
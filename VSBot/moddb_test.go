@@ -0,0 +1,135 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/http/httptest"
+import "net/http"
+import "testing"
+
+// Serves a ModDB style API with one mod, by ID or alias. Anything else gets the API's "not found" reply, which
+// is still a 200.
+func newModDBServer() *httptest.Server {
+	mod := `{"statuscode": "200", "mod": {"modid": 7, "assetid": 1234, "name": "Better Windmills", "urlalias": "betterwindmills",
+		"logofile": "https://example.com/logo.png", "releases": [
+		{"releaseid": 2, "mainfile": "bw_1.1.zip", "modversion": "1.1.0", "tags": ["1.19.0", "1.19.1"],
+			"created": "2024-03-02 10:30:00", "changelog": "<p>Faster &amp; quieter.</p><ul><li>Fixed the sails</li></ul>"},
+		{"releaseid": 1, "mainfile": "bw_1.0.zip", "modversion": "1.0.0", "tags": ["1.19.0"], "created": "2024-01-01 00:00:00"}
+	]}}`
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/mod/7", "/api/mod/betterwindmills":
+			w.Write([]byte(mod))
+		default:
+			w.Write([]byte(`{"statuscode": "404"}`))
+		}
+	}))
+}
+
+func TestGetModDBMod(t *testing.T) {
+	srv := newModDBServer()
+	defer srv.Close()
+	base := ModDBBase
+	ModDBBase = srv.URL
+	defer func() { ModDBBase = base }()
+
+	for _, id := range []string{"7", "betterwindmills"} {
+		mod, err := getModDBMod(id)
+		if err != nil {
+			t.Errorf("getModDBMod(%q): %v", id, err)
+			continue
+		}
+		if mod.ModID != 7 || mod.Name != "Better Windmills" || len(mod.Releases) != 2 {
+			t.Errorf("getModDBMod(%q): wrong mod %+v", id, mod)
+		}
+		if mod.PageURL() != srv.URL+"/betterwindmills" {
+			t.Errorf("wrong page URL: %v", mod.PageURL())
+		}
+	}
+
+	_, err := getModDBMod("nope")
+	if err == nil {
+		t.Errorf("no error for a missing mod")
+	}
+
+	noAlias := &ModDBMod{AssetID: 1234}
+	if noAlias.PageURL() != srv.URL+"/show/mod/1234" {
+		t.Errorf("wrong page URL without an alias: %v", noAlias.PageURL())
+	}
+}
+
+func TestReleaseEmbed(t *testing.T) {
+	srv := newModDBServer()
+	defer srv.Close()
+	base := ModDBBase
+	ModDBBase = srv.URL
+	defer func() { ModDBBase = base }()
+
+	mod, err := getModDBMod("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	embed := releaseEmbed(mod, mod.Releases[0])
+	if embed.Title != "New release: Better Windmills" || embed.URL != mod.PageURL() {
+		t.Errorf("wrong title or URL: %v %v", embed.Title, embed.URL)
+	}
+	if embed.Timestamp != "2024-03-02T10:30:00Z" {
+		t.Errorf("wrong timestamp: %v", embed.Timestamp)
+	}
+	if embed.Thumbnail == nil || embed.Thumbnail.URL != mod.Logo {
+		t.Errorf("missing logo")
+	}
+	if len(embed.Fields) != 3 || embed.Fields[1].Value != "1.19.0, 1.19.1" || embed.Fields[2].Value != "Faster & quieter.\nFixed the sails" {
+		t.Errorf("wrong fields: %+v %+v %+v", embed.Fields[0], embed.Fields[1], embed.Fields[len(embed.Fields)-1])
+	}
+
+	// No changelog, no changelog field.
+	if embed := releaseEmbed(mod, mod.Releases[1]); len(embed.Fields) != 2 {
+		t.Errorf("expected 2 fields, got %v", len(embed.Fields))
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"", 10, ""},
+		{"plain", 10, "plain"},
+		{"<p>one</p>\n\n\n<p>two</p>", 100, "one\ntwo"},
+		{"a<br>b<br/>c", 100, "a\nb\nc"},
+		{"&lt;tag&gt;", 100, "<tag>"},
+		{"0123456789abc", 10, "0123456..."},
+		{"ééééé", 8, "éé..."}, // Cut on a rune boundary.
+	}
+
+	for _, c := range cases {
+		got := excerpt(c.in, c.n)
+		if got != c.want {
+			t.Errorf("excerpt(%q, %d) = %q, want %q", c.in, c.n, got, c.want)
+		}
+	}
+}
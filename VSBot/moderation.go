@@ -0,0 +1,382 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strconv"
+import "strings"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// Pending relays that nobody has looked at for this long are dropped.
+var ModTimeout = 24 * time.Hour
+
+// Queue status values.
+const (
+	modPending  = "pending"
+	modApproved = "approved"
+	modRejected = "rejected"
+	modExpired  = "expired"
+	modDeleted  = "deleted"
+)
+
+// Posts a candidate relay to the rule's mod channel instead of the destination.
+func queueRelay(s *discordgo.Session, rule relayRule, m *discordgo.Message, links []Link) {
+	id, err := addModItem(modItem{
+		SourceCID: m.ChannelID,
+		SourceID:  m.ID,
+		Rule:      rule.ID,
+		Author:    m.Author.ID,
+		Created:   time.Now(),
+	})
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	urls := []string{}
+	for _, link := range links {
+		urls = append(urls, link.String())
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "Relay pending approval",
+		Description: "<@" + m.Author.ID + "> in <#" + m.ChannelID + ">, [original message](" + jumpURL(m) + ").\n" + strings.Join(urls, "\n"),
+		Color:       0x0000ff,
+		Footer:      &discordgo.MessageEmbedFooter{Text: "To: #" + channelName(s, rule.ToChannel)},
+	}
+	mdat, err := s.ChannelMessageSendComplex(rule.ModChannel, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Approve", Style: discordgo.SuccessButton, CustomID: "mod-approve:" + strconv.FormatInt(id, 10)},
+			discordgo.Button{Label: "Reject", Style: discordgo.DangerButton, CustomID: "mod-reject:" + strconv.FormatInt(id, 10)},
+		}}},
+		AllowedMentions: noMentions,
+	})
+	if err != nil {
+		fmt.Println("Error sending message to:", rule.ModChannel, err)
+		err = setModDecision(id, modExpired, "", "", time.Now())
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+		return
+	}
+
+	err = setModMessage(id, mdat.ChannelID, mdat.ID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+}
+
+func channelName(s *discordgo.Session, cid string) string {
+	ch, err := s.State.Channel(cid)
+	if err != nil {
+		return cid
+	}
+	return ch.Name
+}
+
+func modButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	action, id, ok := parseModID(i.MessageComponentData().CustomID)
+	if !ok {
+		return
+	}
+
+	if !isModerator(i) {
+		respondEphemeral(s, i, "Sorry, you can't moderate relays.")
+		return
+	}
+
+	switch action {
+	case "mod-approve":
+		approveRelay(s, i, id)
+	case "mod-reject":
+		// Ask for a reason, the decision is made when the modal comes back.
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: "mod-reason:" + strconv.FormatInt(id, 10),
+				Title:    "Reject relay",
+				Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{CustomID: "reason", Label: "Reason", Style: discordgo.TextInputParagraph, MaxLength: 500},
+				}}},
+			},
+		})
+		if err != nil {
+			fmt.Println("Error responding to interaction:", err)
+		}
+	}
+}
+
+func modModal(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+	action, id, ok := parseModID(data.CustomID)
+	if !ok || action != "mod-reason" {
+		return
+	}
+
+	if !isModerator(i) {
+		respondEphemeral(s, i, "Sorry, you can't moderate relays.")
+		return
+	}
+
+	// Deciding can be slower than the response deadline, so answer now and edit the message when done.
+	if !deferModUpdate(s, i) {
+		return
+	}
+
+	reason := ""
+	if len(data.Components) > 0 {
+		if row, ok := data.Components[0].(*discordgo.ActionsRow); ok && len(row.Components) > 0 {
+			if input, ok := row.Components[0].(*discordgo.TextInput); ok {
+				reason = input.Value
+			}
+		}
+	}
+
+	decided, err := decideModItem(id, modRejected, interactionUser(i), reason, time.Now())
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		followupEphemeral(s, i, "Error, check server logs.")
+		return
+	}
+	if !decided {
+		followupEphemeral(s, i, "That relay was already handled.")
+		return
+	}
+
+	updateModMessage(s, i, "Rejected by <@"+interactionUser(i)+">"+reasonSuffix(reason), 0xff0000)
+}
+
+func approveRelay(s *discordgo.Session, i *discordgo.InteractionCreate, id int64) {
+	// Sending the relay can take longer than the response deadline, so answer now and edit the message when done.
+	if !deferModUpdate(s, i) {
+		return
+	}
+
+	item, err := getModItem(id)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		followupEphemeral(s, i, "Error, check server logs.")
+		return
+	}
+	if item == nil || item.Status != modPending {
+		followupEphemeral(s, i, "That relay was already handled.")
+		return
+	}
+	rule, err := getRule(item.Rule)
+	if err != nil || rule == nil {
+		followupEphemeral(s, i, "The rule for that relay no longer exists.")
+		return
+	}
+
+	m, err := s.ChannelMessage(item.SourceCID, item.SourceID)
+	if err != nil {
+		followupEphemeral(s, i, "Couldn't read the original message, it may have been deleted.")
+		return
+	}
+	m.GuildID = rule.Guild
+
+	// The message may have been edited since it was queued.
+	links := rule.Filter(extractLinks(m))
+	if len(links) == 0 {
+		followupEphemeral(s, i, "The original message no longer has anything to relay.")
+		return
+	}
+	prefetchOEmbed(links)
+
+	defer lockSource(m.ID)()
+
+	decided, err := decideModItem(id, modApproved, interactionUser(i), "", time.Now())
+	if err != nil || !decided {
+		followupEphemeral(s, i, "That relay was already handled.")
+		return
+	}
+
+	relayed, err := sendRelay(s, rule.ToChannel, m, links)
+	if err != nil {
+		fmt.Println("Error sending message to:", rule.ToChannel, err)
+		err = reopenModItem(id)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+		followupEphemeral(s, i, "Couldn't send the relay, it is still waiting for approval.")
+		return
+	}
+	err = addRelay(m.ID, rule.ID, relayed)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+	// Already counted toward the rate limits when it was queued.
+	recordRelay(*rule, m.Author.ID, links, time.Now())
+
+	updateModMessage(s, i, "Approved by <@"+interactionUser(i)+">", 0x00ff00)
+}
+
+// Closes out queue items that were never decided.
+func pollModQueue(s *discordgo.Session) {
+	for {
+		items, err := getStalePending(time.Now().Add(-ModTimeout))
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+		for _, item := range items {
+			closeModItem(s, item, modExpired, "Expired without a decision.")
+		}
+		time.Sleep(1 * time.Minute)
+	}
+}
+
+// Called when a source message goes away while its relay is still pending.
+func dropPending(s *discordgo.Session, sourceID string) {
+	items, err := getPendingFor(sourceID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	for _, item := range items {
+		closeModItem(s, item, modDeleted, "The original message was deleted.")
+	}
+}
+
+func closeModItem(s *discordgo.Session, item modItem, status, note string) {
+	decided, err := decideModItem(item.ID, status, "", "", time.Now())
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if !decided || item.ModMID == "" {
+		return
+	}
+
+	m, err := s.ChannelMessage(item.ModCID, item.ModMID)
+	if err != nil {
+		fmt.Println("Error reading old message:", err)
+		return
+	}
+	if len(m.Embeds) == 0 {
+		fmt.Println("Message with no embed:", item.ModMID)
+		return
+	}
+
+	embed := m.Embeds[0]
+	embed.Color = 0x808080
+	embed.Description += "\n\n" + note
+
+	edit := discordgo.NewMessageEdit(item.ModCID, item.ModMID)
+	edit.Embeds = []*discordgo.MessageEmbed{embed}
+	edit.Components = []discordgo.MessageComponent{}
+	_, err = s.ChannelMessageEditComplex(edit)
+	if err != nil {
+		fmt.Println("Error editing message to:", item.ModMID, err)
+	}
+}
+
+// Acknowledges a button or modal on a queue message, the message itself is edited later by updateModMessage.
+// Discord only waits 3 seconds for a response, anything slower shows the moderator "interaction failed".
+func deferModUpdate(s *discordgo.Session, i *discordgo.InteractionCreate) bool {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		fmt.Println("Error responding to interaction:", err)
+		return false
+	}
+	return true
+}
+
+// Replaces the buttons on the queue message with the decision. The interaction must already be deferred.
+func updateModMessage(s *discordgo.Session, i *discordgo.InteractionCreate, note string, color int) {
+	embeds := []*discordgo.MessageEmbed{}
+	if i.Message != nil && len(i.Message.Embeds) > 0 {
+		embed := i.Message.Embeds[0]
+		embed.Color = color
+		embed.Description += "\n\n" + note
+		embeds = append(embeds, embed)
+	}
+
+	_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds:          &embeds,
+		Components:      &[]discordgo.MessageComponent{},
+		AllowedMentions: noMentions,
+	})
+	if err != nil {
+		fmt.Println("Error editing interaction response:", err)
+	}
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, msg string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: msg, Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		fmt.Println("Error responding to interaction:", err)
+	}
+}
+
+// Like respondEphemeral, for interactions that were already deferred.
+func followupEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, msg string) {
+	_, err := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{Content: msg, Flags: discordgo.MessageFlagsEphemeral})
+	if err != nil {
+		fmt.Println("Error sending followup message:", err)
+	}
+}
+
+func isModerator(i *discordgo.InteractionCreate) bool {
+	if i.Member == nil {
+		return false
+	}
+	perm := i.Member.Permissions
+	return perm&discordgo.PermissionAdministrator != 0 || perm&discordgo.PermissionManageMessages != 0
+}
+
+func interactionUser(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return "."
+	}
+	return ": " + reason
+}
+
+// Custom IDs look like "mod-approve:12".
+func parseModID(cid string) (string, int64, bool) {
+	parts := strings.SplitN(cid, ":", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "mod-") {
+		return "", 0, false
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], id, true
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "encoding/json"
+import "net/http"
+import "net/url"
+import "errors"
+import "fmt"
+import "sync"
+import "time"
+
+// Swap this out (or point the providers' OEmbed fields at something local) to test without hitting the real sites.
+var OEmbedClient = &http.Client{Timeout: 10 * time.Second}
+
+// How long to remember metadata. Failures are remembered for a shorter time so one bad fetch doesn't stick.
+var (
+	OEmbedCacheTime   = 6 * time.Hour
+	OEmbedFailureTime = 10 * time.Minute
+)
+
+// The subset of an oEmbed response we care about.
+type OEmbedData struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	AuthorURL    string `json:"author_url"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+type oembedEntry struct {
+	data    *OEmbedData
+	expires time.Time
+}
+
+var oembedCache = struct {
+	sync.Mutex
+	entries map[string]oembedEntry
+}{entries: map[string]oembedEntry{}}
+
+// Returns metadata for the given link, or nil if the provider has no oEmbed endpoint or the fetch failed.
+func getOEmbed(l Link) *OEmbedData {
+	if l.Provider.OEmbed == "" {
+		return nil
+	}
+	key := l.String()
+
+	oembedCache.Lock()
+	entry, ok := oembedCache.entries[key]
+	oembedCache.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.data
+	}
+
+	data, err := fetchOEmbed(l.Provider.OEmbed, key)
+	if err != nil {
+		fmt.Println("oEmbed Error:", key, err)
+		entry = oembedEntry{nil, time.Now().Add(OEmbedFailureTime)}
+	} else {
+		entry = oembedEntry{data, time.Now().Add(OEmbedCacheTime)}
+	}
+
+	oembedCache.Lock()
+	// Drop anything stale while we are here, so the cache doesn't grow forever.
+	for k, v := range oembedCache.entries {
+		if time.Now().After(v.expires) {
+			delete(oembedCache.entries, k)
+		}
+	}
+	oembedCache.entries[key] = entry
+	oembedCache.Unlock()
+	return entry.data
+}
+
+// Fills the cache for the links, so building a relay later doesn't wait on the network while holding a lock.
+func prefetchOEmbed(links []Link) {
+	for _, l := range links {
+		getOEmbed(l)
+	}
+}
+
+func fetchOEmbed(endpoint, link string) (*OEmbedData, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("url", link)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	r, err := OEmbedClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status: " + r.Status)
+	}
+
+	data := &OEmbedData{}
+	err = json.NewDecoder(r.Body).Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if data.Title == "" {
+		return nil, errors.New("no title in response")
+	}
+	return data, nil
+}
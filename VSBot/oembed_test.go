@@ -0,0 +1,138 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "net/http/httptest"
+import "net/http"
+import "net/url"
+import "strings"
+import "testing"
+
+import "github.com/bwmarrin/discordgo"
+
+// A stand in oEmbed server. Links with "missing" in them get a 404, like the real sites give for deleted videos.
+func newOEmbedServer(t *testing.T, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		link := r.URL.Query().Get("url")
+		if r.URL.Query().Get("format") != "json" {
+			t.Errorf("oEmbed request without format=json: %v", r.URL)
+		}
+		if strings.Contains(link, "missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "Building a windmill", "author_name": "Tyron", "author_url": "https://example.com/tyron", "provider_name": "TestTube", "thumbnail_url": "https://example.com/thumb.jpg"}`))
+	}))
+}
+
+func testLink(t *testing.T, p *Provider, raw string) Link {
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Link{URL: u, Provider: p, Kind: KindVideo}
+}
+
+func TestGetOEmbed(t *testing.T) {
+	requests := 0
+	srv := newOEmbedServer(t, &requests)
+	defer srv.Close()
+	p := &Provider{Name: "testtube", OEmbed: srv.URL}
+
+	link := testLink(t, p, "https://testtube.example/watch?v=windmill")
+	data := getOEmbed(link)
+	if data == nil {
+		t.Fatal("no data from the oEmbed server")
+	}
+	if data.Title != "Building a windmill" || data.AuthorName != "Tyron" || data.ProviderName != "TestTube" {
+		t.Errorf("wrong data: %+v", data)
+	}
+
+	// The second lookup comes from the cache.
+	if getOEmbed(link) == nil || requests != 1 {
+		t.Errorf("expected 1 request, got %v", requests)
+	}
+
+	// Failures come back as nil, and are cached too.
+	missing := testLink(t, p, "https://testtube.example/watch?v=missing")
+	if getOEmbed(missing) != nil || getOEmbed(missing) != nil {
+		t.Errorf("got data for a missing video")
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %v", requests)
+	}
+
+	// No endpoint, no request.
+	if getOEmbed(testLink(t, &Provider{Name: "none"}, "https://none.example/video")) != nil || requests != 2 {
+		t.Errorf("fetched metadata for a provider with no oEmbed endpoint")
+	}
+}
+
+func TestBuildRelay(t *testing.T) {
+	requests := 0
+	srv := newOEmbedServer(t, &requests)
+	defer srv.Close()
+	p := &Provider{Name: "testtube", OEmbed: srv.URL}
+
+	m := &discordgo.Message{
+		ID:        "3",
+		ChannelID: "2",
+		GuildID:   "1",
+		Content:   "look at this https://testtube.example/watch?v=relay",
+		Author:    &discordgo.User{ID: "42"},
+	}
+
+	msg := buildRelay(m, []Link{testLink(t, p, "https://testtube.example/watch?v=relay")}, false)
+	if len(msg.Embeds) != 1 || msg.Content != "" {
+		t.Fatalf("expected one embed and no content, got %+v", msg)
+	}
+	embed := msg.Embeds[0]
+	if embed.Title != "Building a windmill" || embed.URL != "https://testtube.example/watch?v=relay" {
+		t.Errorf("wrong embed: %+v", embed)
+	}
+	if embed.Author == nil || embed.Author.Name != "Tyron" || embed.Thumbnail == nil {
+		t.Errorf("embed is missing the author or thumbnail: %+v", embed)
+	}
+	if !strings.Contains(embed.Description, "<@42>") || !strings.Contains(embed.Description, "https://discord.com/channels/1/2/3") {
+		t.Errorf("embed doesn't credit the poster: %v", embed.Description)
+	}
+
+	// Without metadata it falls back to plain text.
+	missing := []Link{testLink(t, p, "https://testtube.example/watch?v=missing")}
+	msg = buildRelay(m, missing, false)
+	if len(msg.Embeds) != 0 || msg.Content != "<@42>: "+m.Content {
+		t.Errorf("wrong plain text relay: %+v", msg)
+	}
+	msg = buildRelay(m, missing, true)
+	if len(msg.Embeds) != 0 || msg.Content != m.Content {
+		t.Errorf("wrong plain text webhook relay: %+v", msg)
+	}
+
+	// A mix gets the embeds, with the rest listed in the content.
+	msg = buildRelay(m, []Link{testLink(t, p, "https://testtube.example/watch?v=relay"), missing[0]}, false)
+	if len(msg.Embeds) != 1 || msg.Content != missing[0].String() {
+		t.Errorf("wrong mixed relay: %+v", msg)
+	}
+}
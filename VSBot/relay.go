@@ -0,0 +1,264 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "sync"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// Create and update events for the same message can race (Discord sends an update as soon as it
+// unfurls the links), so only handle one at a time per message. Different messages only share the duplicate
+// and rate limit records, limitLock keeps the check and the record that follows it together.
+var (
+	sourceLocks = struct {
+		sync.Mutex
+		locks map[string]*sourceLock
+	}{locks: map[string]*sourceLock{}}
+
+	limitLock sync.Mutex
+)
+
+type sourceLock struct {
+	sync.Mutex
+	users int
+}
+
+// Locks a source message, call the returned function to unlock it.
+func lockSource(id string) func() {
+	sourceLocks.Lock()
+	l, ok := sourceLocks.locks[id]
+	if !ok {
+		l = &sourceLock{}
+		sourceLocks.locks[id] = l
+	}
+	l.users++
+	sourceLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		sourceLocks.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(sourceLocks.locks, id)
+		}
+		sourceLocks.Unlock()
+	}
+}
+
+// Makes the relayed copies of a message match what the rules say they should be. Used for both new
+// and edited messages: relays are created, edited, or deleted as needed. created is set for new messages,
+// the author is only told about skipped relays then, not again on every edit.
+func syncRelays(s *discordgo.Session, m *discordgo.Message, created bool) {
+	// Nothing here needs a lock, and the lookups can be slow.
+	links := extractLinks(m)
+	prefetchOEmbed(links)
+
+	defer lockSource(m.ID)()
+
+	rules, err := getRulesFrom(m.ChannelID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	existing, err := getRelays(m.ID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	for _, rule := range rules {
+		matched := rule.Filter(links)
+		old, ok := existing[rule.ID]
+
+		switch {
+		case len(matched) == 0 && ok:
+			err := deleteRelay(s, old)
+			if err != nil {
+				fmt.Println("Error deleting message:", old.MID, err)
+			}
+			err = removeRelay(m.ID, rule.ID)
+			if err != nil {
+				fmt.Println("DB Error:", err)
+			}
+		case len(matched) == 0:
+			continue
+		case ok:
+			err := editRelay(s, old, m, matched)
+			if err != nil {
+				fmt.Println("Error editing message:", old.MID, err)
+			}
+		default:
+			reason := newRelay(s, rule, m, matched)
+			if reason != "" && created {
+				skipNotice(s, m, reason)
+			}
+		}
+	}
+}
+
+// Relays (or queues) a message that hasn't been relayed by the rule yet. Returns why it was skipped, if it was.
+func newRelay(s *discordgo.Session, rule relayRule, m *discordgo.Message, matched []Link) string {
+	// Edits don't get a second chance at moderation, or another spot in the queue.
+	if rule.ModChannel != "" {
+		queued, err := hasQueued(m.ID, rule.ID)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			return ""
+		}
+		if queued {
+			return ""
+		}
+	}
+
+	limitLock.Lock()
+	defer limitLock.Unlock()
+
+	now := time.Now()
+	matched, err := dropDuplicates(rule, matched, now)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return ""
+	}
+	if len(matched) == 0 {
+		return "that was already shared recently"
+	}
+	reason, err := checkRateLimit(rule, m.Author.ID, now)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return ""
+	}
+	if reason != "" {
+		return reason
+	}
+
+	if rule.ModChannel != "" {
+		// Queued relays count toward the rate limits, so nobody can flood the mod channel.
+		logRelay(rule, m.Author.ID, now)
+		queueRelay(s, rule, m, matched)
+		return ""
+	}
+
+	// The metadata was fetched before any locks were taken, so this is only the send.
+	relayed, err := sendRelay(s, rule.ToChannel, m, matched)
+	if err != nil {
+		fmt.Println("Error sending message to:", rule.ToChannel, err)
+		return ""
+	}
+	err = addRelay(m.ID, rule.ID, relayed)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+	logRelay(rule, m.Author.ID, now)
+	recordRelay(rule, m.Author.ID, matched, now)
+	return ""
+}
+
+// Deletes every relayed copy of the given source message, and drops any copies still waiting on a moderator.
+func deleteRelays(s *discordgo.Session, mid string) {
+	defer lockSource(mid)()
+
+	dropPending(s, mid)
+
+	existing, err := getRelays(mid)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	for rule, old := range existing {
+		err := deleteRelay(s, old)
+		if err != nil {
+			fmt.Println("Error deleting message:", old.MID, err)
+		}
+		err = removeRelay(mid, rule)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+	}
+}
+
+// Discord won't take more than this many embeds on one message.
+const maxEmbeds = 10
+
+// Builds the relayed copy of a message. Links with metadata get an embed, if none have any we fall back
+// to the plain old "<@author>: content" format. Webhook relays already show the author, so they skip the prefix.
+func buildRelay(m *discordgo.Message, links []Link, webhook bool) *discordgo.MessageSend {
+	jump := jumpURL(m)
+
+	embeds := []*discordgo.MessageEmbed{}
+	plain := []string{}
+	for _, link := range links {
+		data := getOEmbed(link)
+		if data == nil || len(embeds) >= maxEmbeds {
+			plain = append(plain, link.String())
+			continue
+		}
+
+		embed := &discordgo.MessageEmbed{
+			Title:       data.Title,
+			URL:         link.String(),
+			Description: "Shared by <@" + m.Author.ID + "> in <#" + m.ChannelID + ">, [original message](" + jump + ").",
+			Color:       0x7f5a3a,
+			Footer:      &discordgo.MessageEmbedFooter{Text: data.ProviderName + " " + string(link.Kind)},
+		}
+		if data.AuthorName != "" {
+			embed.Author = &discordgo.MessageEmbedAuthor{Name: data.AuthorName, URL: data.AuthorURL}
+		}
+		if data.ThumbnailURL != "" {
+			embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: data.ThumbnailURL}
+		}
+		embeds = append(embeds, embed)
+	}
+
+	if len(embeds) == 0 {
+		if webhook {
+			return &discordgo.MessageSend{Content: m.Content}
+		}
+		return &discordgo.MessageSend{Content: "<@" + m.Author.ID + ">: " + m.Content}
+	}
+	return &discordgo.MessageSend{Content: strings.Join(plain, "\n"), Embeds: embeds}
+}
+
+func jumpURL(m *discordgo.Message) string {
+	return "https://discord.com/channels/" + m.GuildID + "/" + m.ChannelID + "/" + m.ID
+}
+
+// Returns the links the rule cares about.
+func (r relayRule) Filter(links []Link) []Link {
+	out := []Link{}
+	for _, link := range links {
+		if r.Matches(link) {
+			out = append(out, link)
+		}
+	}
+	return out
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "sync"
+import "time"
+import "net"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+var (
+	// Servers to monitor.
+	Servers = []GameServer{
+		//{"Main", "play.example.com:42420", "tcp"},
+	}
+
+	// Each of these channels gets a status embed that is kept up to date, plus up/down alerts.
+	StatusChannels = []string{}
+
+	ServerPollTime = 1 * time.Minute
+
+	// A server has to fail this many probes in a row before it counts as down.
+	DownAfter = 2
+)
+
+type GameServer struct {
+	Name    string
+	Address string // host:port
+	Probe   string // Key in Probes.
+}
+
+// ServerStatus is the result of one probe.
+type ServerStatus struct {
+	Up      bool
+	Latency time.Duration
+
+	// Negative if the probe can't tell.
+	Players    int
+	MaxPlayers int
+
+	Version string // Empty if the probe can't tell.
+}
+
+// Probe checks if a server is up. Richer probes can fill in more of the status.
+type Probe interface {
+	Probe(addr string) ServerStatus
+}
+
+// The probe registry.
+var Probes = map[string]Probe{
+	"tcp": TCPProbe{Timeout: 5 * time.Second},
+}
+
+// TCPProbe only checks if the port accepts connections.
+type TCPProbe struct {
+	Timeout time.Duration
+}
+
+func (p TCPProbe) Probe(addr string) ServerStatus {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, p.Timeout)
+	if err != nil {
+		return ServerStatus{Players: -1, MaxPlayers: -1}
+	}
+	conn.Close()
+	return ServerStatus{Up: true, Latency: time.Since(start), Players: -1, MaxPlayers: -1}
+}
+
+type serverState struct {
+	Status   ServerStatus
+	Up       bool // After DownAfter is taken into account.
+	Failures int
+	Checked  time.Time
+	Since    time.Time // When Up last changed.
+}
+
+var serverStates = struct {
+	sync.Mutex
+	states map[string]*serverState
+}{states: map[string]*serverState{}}
+
+func pollServers(s *discordgo.Session) {
+	if len(Servers) == 0 {
+		return
+	}
+
+	for {
+		alerts := checkServers()
+		for _, alert := range alerts {
+			for _, cid := range StatusChannels {
+				_, err := s.ChannelMessageSend(cid, alert)
+				if err != nil {
+					fmt.Println("Error sending message to:", cid, err)
+				}
+			}
+		}
+
+		embed := serverEmbed()
+		for _, cid := range StatusChannels {
+			updateStatusMessage(s, cid, embed)
+		}
+
+		time.Sleep(ServerPollTime)
+	}
+}
+
+// Probes every server and returns alert messages for any that went down or came back.
+func checkServers() []string {
+	results := make([]ServerStatus, len(Servers))
+	wg := sync.WaitGroup{}
+	for i, srv := range Servers {
+		probe, ok := Probes[srv.Probe]
+		if !ok {
+			fmt.Println("Unknown probe:", srv.Probe, "for server:", srv.Name)
+			results[i] = ServerStatus{Players: -1, MaxPlayers: -1}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			results[i] = probe.Probe(addr)
+		}(i, srv.Address)
+	}
+	wg.Wait()
+
+	serverStates.Lock()
+	defer serverStates.Unlock()
+
+	alerts := []string{}
+	now := time.Now()
+	for i, srv := range Servers {
+		status := results[i]
+		state, ok := serverStates.states[srv.Name]
+		if !ok {
+			// First check, no alerts.
+			serverStates.states[srv.Name] = &serverState{Status: status, Up: status.Up, Checked: now, Since: now}
+			continue
+		}
+
+		state.Status, state.Checked = status, now
+		if status.Up {
+			state.Failures = 0
+		} else {
+			state.Failures++
+		}
+
+		switch {
+		case status.Up && !state.Up:
+			alerts = append(alerts, fmt.Sprintf(":green_circle: **%v** is back up after %v.", srv.Name, now.Sub(state.Since).Round(time.Minute)))
+			state.Up, state.Since = true, now
+		case !status.Up && state.Up && state.Failures >= DownAfter:
+			alerts = append(alerts, fmt.Sprintf(":red_circle: **%v** is down.", srv.Name))
+			state.Up, state.Since = false, now
+		}
+	}
+	return alerts
+}
+
+func serverEmbed() *discordgo.MessageEmbed {
+	serverStates.Lock()
+	defer serverStates.Unlock()
+
+	fields := []*discordgo.MessageEmbedField{}
+	color := 0x00ff00
+	for _, srv := range Servers {
+		state, ok := serverStates.states[srv.Name]
+		if !ok {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: srv.Name, Value: "Not checked yet.", Inline: true})
+			continue
+		}
+
+		lines := []string{}
+		if state.Up {
+			lines = append(lines, ":green_circle: Up")
+			if state.Status.Up {
+				lines = append(lines, fmt.Sprintf("Ping: %dms", state.Status.Latency.Milliseconds()))
+			}
+			if state.Status.Players >= 0 {
+				if state.Status.MaxPlayers > 0 {
+					lines = append(lines, fmt.Sprintf("Players: %d/%d", state.Status.Players, state.Status.MaxPlayers))
+				} else {
+					lines = append(lines, fmt.Sprintf("Players: %d", state.Status.Players))
+				}
+			}
+			if state.Status.Version != "" {
+				lines = append(lines, "Version: "+state.Status.Version)
+			}
+		} else {
+			color = 0xff0000
+			lines = append(lines, ":red_circle: Down for "+time.Since(state.Since).Round(time.Minute).String())
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: srv.Name, Value: strings.Join(lines, "\n"), Inline: true})
+	}
+
+	desc := ""
+	if len(Servers) == 0 {
+		desc = "No servers are being monitored."
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Server Status",
+		Description: desc,
+		Color:       color,
+		Fields:      fields,
+		Footer:      &discordgo.MessageEmbedFooter{Text: "Last checked"},
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+}
+
+// Edits the channel's status message, or posts a new one if there isn't one (or it was deleted).
+func updateStatusMessage(s *discordgo.Session, cid string, embed *discordgo.MessageEmbed) {
+	mid, err := getStatusMessage(cid)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	if mid != "" {
+		_, err := s.ChannelMessageEditEmbed(cid, mid, embed)
+		if err == nil {
+			return
+		}
+		fmt.Println("Error editing message to:", mid, err)
+	}
+
+	mdat, err := s.ChannelMessageSendEmbed(cid, embed)
+	if err != nil {
+		fmt.Println("Error sending message to:", cid, err)
+		return
+	}
+	err = setStatusMessage(cid, mdat.ID)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+}
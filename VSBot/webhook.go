@@ -0,0 +1,177 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "sync"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// Relay through a webhook in the destination channel, so posts show up with the author's name and avatar.
+// Channels where we lack Manage Webhooks get the normal bot messages.
+var RelayWebhooks = false
+
+// Used for webhooks we create, so we can find them again.
+const webhookName = "VSBot Relay"
+
+// No pings from relayed content, ever.
+var noMentions = &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}}
+
+var webhookLock sync.Mutex
+
+// Returns the relay webhook for a channel, creating it if needed. Returns nil if webhooks are off or
+// we don't have the permissions.
+func getWebhook(s *discordgo.Session, cid string) *webhook {
+	if !RelayWebhooks {
+		return nil
+	}
+
+	perm, err := s.State.UserChannelPermissions(s.State.User.ID, cid)
+	if err != nil || perm&discordgo.PermissionManageWebhooks == 0 {
+		return nil
+	}
+
+	webhookLock.Lock()
+	defer webhookLock.Unlock()
+
+	wh, err := getStoredWebhook(cid)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return nil
+	}
+	if wh != nil {
+		return wh
+	}
+
+	// Maybe we made one before and lost the DB.
+	hooks, err := s.ChannelWebhooks(cid)
+	if err != nil {
+		fmt.Println("Error listing webhooks:", cid, err)
+		return nil
+	}
+	for _, h := range hooks {
+		if h.Name == webhookName && h.User != nil && h.User.ID == s.State.User.ID && h.Token != "" {
+			wh = &webhook{CID: cid, ID: h.ID, Token: h.Token}
+			break
+		}
+	}
+	if wh == nil {
+		h, err := s.WebhookCreate(cid, webhookName, "")
+		if err != nil {
+			fmt.Println("Error creating webhook:", cid, err)
+			return nil
+		}
+		wh = &webhook{CID: cid, ID: h.ID, Token: h.Token}
+	}
+
+	err = addWebhook(*wh)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+	return wh
+}
+
+// Forget a webhook that stopped working (most likely someone deleted it).
+func dropWebhook(cid string) {
+	webhookLock.Lock()
+	defer webhookLock.Unlock()
+
+	err := removeWebhook(cid)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+}
+
+func displayName(m *discordgo.Message) string {
+	if m.Member != nil && m.Member.Nick != "" {
+		return m.Member.Nick
+	}
+	return m.Author.Username
+}
+
+// Sends a relay, through the webhook if possible. The returned message has the webhook ID set if one was used.
+func sendRelay(s *discordgo.Session, cid string, m *discordgo.Message, links []Link) (message, error) {
+	if wh := getWebhook(s, cid); wh != nil {
+		send := buildRelay(m, links, true)
+		mdat, err := s.WebhookExecute(wh.ID, wh.Token, true, &discordgo.WebhookParams{
+			Content:         send.Content,
+			Username:        displayName(m),
+			AvatarURL:       m.Author.AvatarURL(""),
+			Embeds:          send.Embeds,
+			AllowedMentions: noMentions,
+		})
+		if err == nil {
+			return message{CID: cid, MID: mdat.ID, Webhook: wh.ID}, nil
+		}
+		fmt.Println("Error sending webhook message to:", cid, err)
+		dropWebhook(cid)
+	}
+
+	send := buildRelay(m, links, false)
+	send.AllowedMentions = &discordgo.MessageAllowedMentions{Users: []string{m.Author.ID}}
+	mdat, err := s.ChannelMessageSendComplex(cid, send)
+	if err != nil {
+		return message{}, err
+	}
+	return message{CID: cid, MID: mdat.ID}, nil
+}
+
+func editRelay(s *discordgo.Session, old message, m *discordgo.Message, links []Link) error {
+	send := buildRelay(m, links, old.Webhook != "")
+	if send.Embeds == nil {
+		send.Embeds = []*discordgo.MessageEmbed{}
+	}
+
+	if old.Webhook != "" {
+		wh, err := getStoredWebhook(old.CID)
+		if err != nil {
+			return err
+		}
+		if wh == nil || wh.ID != old.Webhook {
+			return fmt.Errorf("webhook %v for channel %v is gone", old.Webhook, old.CID)
+		}
+		_, err = s.WebhookMessageEdit(wh.ID, wh.Token, old.MID, &discordgo.WebhookEdit{
+			Content:         &send.Content,
+			Embeds:          &send.Embeds,
+			AllowedMentions: noMentions,
+		})
+		return err
+	}
+
+	edit := discordgo.NewMessageEdit(old.CID, old.MID)
+	edit.Content = &send.Content
+	edit.Embeds = send.Embeds
+	_, err := s.ChannelMessageEditComplex(edit)
+	return err
+}
+
+func deleteRelay(s *discordgo.Session, old message) error {
+	if old.Webhook != "" {
+		wh, err := getStoredWebhook(old.CID)
+		if err == nil && wh != nil && wh.ID == old.Webhook {
+			return s.WebhookMessageDelete(wh.ID, wh.Token, old.MID)
+		}
+		// Fall through, with Manage Messages we can still delete it.
+	}
+	return s.ChannelMessageDelete(old.CID, old.MID)
+}
@@ -24,9 +24,13 @@ package main
 
 import _ "github.com/mattn/go-sqlite3"
 import "database/sql"
+import "time"
 
 var DB *sql.DB
 
+// Where the database lives, tests point this somewhere temporary.
+var DBPath = "file:/app/feeds.db"
+
 var InitCode = `
 create table if not exists ReadStories (
 	ID integer primary key,
@@ -36,11 +40,29 @@ create table if not exists ReadStories (
 
 	Published integer
 );
+
+create table if not exists FeedStories (
+	Feed text,
+	URL text,
+
+	primary key (Feed, URL)
+);
+
+create table if not exists LateNotices (
+	Feed text,
+	Slot integer
+);
 `
 
 var Queries = map[string]*queryHolder{
-	"StoryInsert":   &queryHolder{`insert into ReadStories (Name, URL, Published) values (?, ?, ?);`, nil},
-	"StoryList":     &queryHolder{`select URL from ReadStories;`, nil},
+	"StoryInsert": &queryHolder{`insert into ReadStories (Name, URL, Published) values (?, ?, ?);`, nil},
+	"StoryList":   &queryHolder{`select URL from ReadStories;`, nil},
+
+	"FeedStoryInsert": &queryHolder{`insert or ignore into FeedStories (Feed, URL) values (?, ?);`, nil},
+	"FeedPublished":   &queryHolder{`select r.Published from ReadStories r join FeedStories f on r.URL = f.URL where f.Feed = ? and r.Published >= ?;`, nil},
+
+	"LateNoticeInsert": &queryHolder{`insert into LateNotices (Feed, Slot) values (?, ?);`, nil},
+	"LateNoticeCount":  &queryHolder{`select count(*) from LateNotices where Feed = ? and Slot = ?;`, nil},
 }
 
 func addStory(name, url string, published int64) error {
@@ -67,9 +89,45 @@ func getStories() (map[string]bool, error) {
 	return stories, nil
 }
 
-func init() {
+func addFeedStory(feed, url string) error {
+	_, err := Queries["FeedStoryInsert"].Preped.Exec(feed, url)
+	return err
+}
+
+// Publish times of all stories in the given feed published at or after since.
+func getFeedPublished(feed string, since time.Time) ([]time.Time, error) {
+	rows, err := Queries["FeedPublished"].Preped.Query(feed, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	published := []time.Time{}
+	for rows.Next() {
+		var t int64
+		err := rows.Scan(&t)
+		if err != nil {
+			return nil, err
+		}
+		published = append(published, time.Unix(t, 0))
+	}
+	return published, nil
+}
+
+func addLateNotice(feed string, slot int64) error {
+	_, err := Queries["LateNoticeInsert"].Preped.Exec(feed, slot)
+	return err
+}
+
+func hasLateNotice(feed string, slot int64) (bool, error) {
+	n := 0
+	err := Queries["LateNoticeCount"].Preped.QueryRow(feed, slot).Scan(&n)
+	return n > 0, err
+}
+
+func openDB() {
 	var err error
-	DB, err = sql.Open("sqlite3", "file:/app/feeds.db")
+	DB, err = sql.Open("sqlite3", DBPath)
 	if err != nil {
 		panic(err)
 	}
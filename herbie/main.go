@@ -38,10 +38,16 @@ var (
 	APIKey string
 	Site   = "https://ceruleanscrawling.wordpress.com"
 	Feeds  = []Feed{
-		{"/category/summus-proelium/feed", []string{"543593314746761228"}, "<@&850455939625517096>"},
-		{"/category/uncategorized/feed", []string{"383419886250098691"}, "@everyone"},
-		{"/category/heretical-edge/feed", []string{"383419886250098691"}, "<@&850455420912140320>"},
-		//{"/feed", []string{"383419886250098691"}, "@everyone"}, // Site wide feed. No longer used.
+		{"/category/summus-proelium/feed", []string{"543593314746761228"}, "<@&850455939625517096>", nil},
+		{"/category/uncategorized/feed", []string{"383419886250098691"}, "@everyone", nil},
+		{"/category/heretical-edge/feed", []string{"383419886250098691"}, "<@&850455420912140320>", nil},
+		//{"/feed", []string{"383419886250098691"}, "@everyone", nil}, // Site wide feed. No longer used.
+
+		// Example schedule:
+		//{"/category/heretical-edge/feed", []string{"383419886250098691"}, "<@&850455420912140320>", &Schedule{
+		//	Days: []time.Weekday{time.Tuesday, time.Saturday}, Hour: 12, Zone: "America/New_York",
+		//	Grace: 3 * time.Hour, Streak: true,
+		//}},
 	}
 )
 
@@ -49,10 +55,12 @@ type Feed struct {
 	URL      string
 	Channels []string
 	Role     string
+	Schedule *Schedule // May be nil if there is no regular schedule.
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
+	openDB()
 
 	// Spin up the server.
 
@@ -89,6 +97,12 @@ func main() {
 			}
 
 			for _, item := range feed.Items {
+				// Track which feeds each story was in, so the schedule stuff can tell them apart.
+				err := addFeedStory(fdata.URL, item.Link)
+				if err != nil {
+					fmt.Println("DB Error:", err)
+				}
+
 				if !stories[item.Link] {
 					fmt.Println("New Post: " + item.Link)
 					if item.PublishedParsed != nil {
@@ -98,8 +112,9 @@ func main() {
 					}
 					stories[item.Link] = true // Needed so that if the next feed in the list has this too it will suppress it.
 
+					streak := streakMessage(fdata, time.Now())
 					for _, id := range fdata.Channels {
-						_, err := dg.ChannelMessageSend(id, fdata.Role+" New Post: "+item.Link)
+						_, err := dg.ChannelMessageSend(id, fdata.Role+" New Post: "+item.Link+streak)
 						if err != nil {
 							fmt.Println("Error sending message to:", id, err)
 						}
@@ -108,6 +123,8 @@ func main() {
 			}
 		}
 
+		checkSchedules(dg, time.Now())
+
 		time.Sleep(1 * time.Minute)
 	}
 	//dg.Close()
@@ -144,8 +161,11 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 			fmt.Println("Error responding to hey from:", m.ChannelID, err)
 		}
 	case "Herbie?":
-		_, err := s.ChannelMessageSend(m.ChannelID, "Try: `Hey Herbie!`. Herbie may also do fun things if you wish him a happy birthday at the right time of year...")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Try: `Hey Herbie!` or `Herbie, when?`. Herbie may also do fun things if you wish him a happy birthday at the right time of year...")
 		fmt.Println("Error responding to question from:", m.ChannelID, err)
+	case "Herbie, when?":
+		_, err := s.ChannelMessageSend(m.ChannelID, whenMessage(m.ChannelID, time.Now()))
+		fmt.Println("Error responding to when from:", m.ChannelID, err)
 	default:
 		t, msg := time.Now(), strings.ToLower(m.Content)
 		// September 4th, the day Flick throws Herbie through the portal.
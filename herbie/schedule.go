@@ -0,0 +1,264 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "time"
+import "fmt"
+
+// The docker image has no zoneinfo, so embed it.
+import _ "time/tzdata"
+
+import "github.com/bwmarrin/discordgo"
+
+// How early a post may go up and still count for a slot.
+var EarlyWindow = 12 * time.Hour
+
+// How late a post may go up and still count as on time, for the streak and for "when".
+var LateWindow = 1 * time.Hour
+
+// Schedule is the expected posting schedule for a feed.
+type Schedule struct {
+	Days   []time.Weekday
+	Hour   int
+	Minute int
+	Zone   string // IANA name, "America/New_York" or the like.
+
+	// How long after the expected time to wait before posting a "running late" notice. Zero disables the notice.
+	Grace time.Duration
+
+	// Mention the on time streak when a new post goes up.
+	Streak bool
+}
+
+func (s *Schedule) location() *time.Location {
+	loc, err := time.LoadLocation(s.Zone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (s *Schedule) slot(day time.Time) (time.Time, bool) {
+	for _, wd := range s.Days {
+		if day.Weekday() == wd {
+			c := time.Date(day.Year(), day.Month(), day.Day(), s.Hour, s.Minute, 0, 0, day.Location())
+
+			// If the time falls in a DST gap time.Date may land before it, push it past the gap instead.
+			if off := time.Duration(s.Hour-c.Hour())*time.Hour + time.Duration(s.Minute-c.Minute())*time.Minute; off > 0 {
+				c = c.Add(off)
+			}
+			return c, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Next returns the first expected post time after t.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.location())
+	for i := 0; i <= 7; i++ {
+		c, ok := s.slot(t.AddDate(0, 0, i))
+		if ok && c.After(t) {
+			return c
+		}
+	}
+	return time.Time{}
+}
+
+// Prev returns the last expected post time at or before t.
+func (s *Schedule) Prev(t time.Time) time.Time {
+	t = t.In(s.location())
+	for i := 0; i <= 7; i++ {
+		c, ok := s.slot(t.AddDate(0, 0, -i))
+		if ok && !c.After(t) {
+			return c
+		}
+	}
+	return time.Time{}
+}
+
+// Posted reports if any of the given publish times count toward the slot. This doesn't depend on Grace, a
+// schedule without late notices still needs a little slack.
+func (s *Schedule) Posted(slot time.Time, published []time.Time) bool {
+	for _, p := range published {
+		if !p.Before(slot.Add(-EarlyWindow)) && !p.After(slot.Add(LateWindow)) {
+			return true
+		}
+	}
+	return false
+}
+
+func postedSince(t time.Time, published []time.Time) bool {
+	for _, p := range published {
+		if !p.Before(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Streak counts how many slots in a row (ending with the last slot at or before t) had a post on time.
+func (s *Schedule) streak(t time.Time, published []time.Time) int {
+	n := 0
+	for slot := s.Prev(t); !slot.IsZero(); slot = s.Prev(slot.Add(-time.Second)) {
+		if !s.Posted(slot, published) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func feedName(f Feed) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(f.URL, "/category/"), "/feed")
+	return strings.Title(strings.ReplaceAll(name, "-", " "))
+}
+
+func formatWait(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days, hours, mins := d/(24*time.Hour), (d%(24*time.Hour))/time.Hour, (d%time.Hour)/time.Minute
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, mins)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, mins)
+	}
+	return fmt.Sprintf("%dm", mins)
+}
+
+// Answer for "Herbie, when?" in the given channel.
+func whenMessage(cid string, now time.Time) string {
+	msg := ""
+	for _, fdata := range Feeds {
+		if fdata.Schedule == nil || !hasChannel(fdata, cid) {
+			continue
+		}
+		sched := fdata.Schedule
+		next := sched.Next(now)
+		if next.IsZero() {
+			continue
+		}
+
+		published, err := getFeedPublished(fdata.URL, now.Add(-8*24*time.Hour))
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			msg += fmt.Sprintf("\n%v: Herbie lost track of this one, ask again later.", feedName(fdata))
+			continue
+		}
+
+		prev := sched.Prev(now)
+		if !prev.IsZero() && !postedSince(prev.Add(-EarlyWindow), published) {
+			if now.After(prev.Add(LateWindow)) {
+				msg += fmt.Sprintf("\n%v: Running a little behind, it was expected %v ago.", feedName(fdata), formatWait(now.Sub(prev)))
+			} else {
+				msg += fmt.Sprintf("\n%v: Due any minute now, it was expected %v ago.", feedName(fdata), formatWait(now.Sub(prev)))
+			}
+			continue
+		}
+		msg += fmt.Sprintf("\n%v: Next post expected in %v (%v).", feedName(fdata), formatWait(next.Sub(now)), next.Format("Mon 15:04 MST"))
+	}
+	if msg == "" {
+		return "Herbie doesn't know of any schedule for this channel."
+	}
+	return strings.TrimPrefix(msg, "\n")
+}
+
+// Suffix for a new post message, empty unless the feed tracks streaks and the post is on time.
+func streakMessage(fdata Feed, now time.Time) string {
+	sched := fdata.Schedule
+	if sched == nil || !sched.Streak {
+		return ""
+	}
+
+	// If the next slot is close enough count the post toward that.
+	at := now
+	if next := sched.Next(now); !next.IsZero() && !now.Before(next.Add(-EarlyWindow)) {
+		at = next
+	}
+
+	published, err := getFeedPublished(fdata.URL, at.Add(-60*24*time.Hour))
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return ""
+	}
+	n := sched.streak(at, published)
+	if n < 2 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d on time in a row!)", n)
+}
+
+// Posts a "running late" notice once per missed slot.
+func checkSchedules(s *discordgo.Session, now time.Time) {
+	for _, fdata := range Feeds {
+		sched := fdata.Schedule
+		if sched == nil || sched.Grace == 0 {
+			continue
+		}
+
+		slot := sched.Prev(now)
+		if slot.IsZero() || now.Before(slot.Add(sched.Grace)) {
+			continue
+		}
+
+		published, err := getFeedPublished(fdata.URL, slot.Add(-EarlyWindow))
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			continue
+		}
+		if postedSince(slot.Add(-EarlyWindow), published) {
+			continue
+		}
+
+		noticed, err := hasLateNotice(fdata.URL, slot.Unix())
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			continue
+		}
+		if noticed {
+			continue
+		}
+		err = addLateNotice(fdata.URL, slot.Unix())
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			continue
+		}
+
+		for _, id := range fdata.Channels {
+			_, err := s.ChannelMessageSend(id, "Herbie has noticed the next "+feedName(fdata)+" chapter is running a little late. Patience!")
+			if err != nil {
+				fmt.Println("Error sending message to:", id, err)
+			}
+		}
+	}
+}
+
+func hasChannel(f Feed, cid string) bool {
+	for _, id := range f.Channels {
+		if id == cid {
+			return true
+		}
+	}
+	return false
+}
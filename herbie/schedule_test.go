@@ -0,0 +1,160 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "testing"
+import "time"
+
+func mustZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip("no zone data:", err)
+	}
+	return loc
+}
+
+func TestScheduleNextPrev(t *testing.T) {
+	ny := mustZone(t, "America/New_York")
+	sched := &Schedule{Days: []time.Weekday{time.Sunday, time.Wednesday}, Hour: 2, Minute: 30, Zone: "America/New_York"}
+	daily := &Schedule{Days: []time.Weekday{0, 1, 2, 3, 4, 5, 6}, Hour: 18, Minute: 0, Zone: "America/New_York"}
+
+	cases := []struct {
+		name       string
+		sched      *Schedule
+		at         time.Time
+		next, prev time.Time
+	}{
+		{
+			name:  "midweek",
+			sched: sched,
+			at:    time.Date(2024, 2, 5, 12, 0, 0, 0, ny),
+			next:  time.Date(2024, 2, 7, 2, 30, 0, 0, ny),
+			prev:  time.Date(2024, 2, 4, 2, 30, 0, 0, ny),
+		},
+		{
+			name:  "exactly on the slot",
+			sched: sched,
+			at:    time.Date(2024, 2, 7, 2, 30, 0, 0, ny),
+			next:  time.Date(2024, 2, 11, 2, 30, 0, 0, ny),
+			prev:  time.Date(2024, 2, 7, 2, 30, 0, 0, ny),
+		},
+		{
+			name:  "input in another zone",
+			sched: sched,
+			at:    time.Date(2024, 2, 7, 7, 29, 0, 0, time.UTC),
+			next:  time.Date(2024, 2, 7, 2, 30, 0, 0, ny),
+			prev:  time.Date(2024, 2, 4, 2, 30, 0, 0, ny),
+		},
+		{
+			// 2:30 doesn't exist on the day clocks go forward, it ends up at 3:30 EDT.
+			name:  "spring forward",
+			sched: sched,
+			at:    time.Date(2024, 3, 9, 12, 0, 0, 0, ny),
+			next:  time.Date(2024, 3, 10, 3, 30, 0, 0, ny),
+			prev:  time.Date(2024, 3, 6, 2, 30, 0, 0, ny),
+		},
+		{
+			name:  "after spring forward",
+			sched: daily,
+			at:    time.Date(2024, 3, 10, 12, 0, 0, 0, ny),
+			next:  time.Date(2024, 3, 10, 22, 0, 0, 0, time.UTC),
+			prev:  time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "after fall back",
+			sched: daily,
+			at:    time.Date(2024, 11, 3, 12, 0, 0, 0, ny),
+			next:  time.Date(2024, 11, 3, 23, 0, 0, 0, time.UTC),
+			prev:  time.Date(2024, 11, 2, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "no days",
+			sched: &Schedule{Zone: "America/New_York"},
+			at:    time.Date(2024, 2, 5, 12, 0, 0, 0, ny),
+		},
+	}
+
+	for _, c := range cases {
+		if got := c.sched.Next(c.at); !got.Equal(c.next) {
+			t.Errorf("%v: Next = %v, want %v", c.name, got, c.next)
+		}
+		if got := c.sched.Prev(c.at); !got.Equal(c.prev) {
+			t.Errorf("%v: Prev = %v, want %v", c.name, got, c.prev)
+		}
+	}
+}
+
+func TestSchedulePosted(t *testing.T) {
+	ny := mustZone(t, "America/New_York")
+	sched := &Schedule{Days: []time.Weekday{time.Monday}, Hour: 9, Minute: 0, Zone: "America/New_York"}
+	slot := time.Date(2024, 2, 5, 9, 0, 0, 0, ny)
+
+	cases := []struct {
+		name      string
+		grace     time.Duration
+		published []time.Duration // Relative to the slot.
+		want      bool
+	}{
+		{"nothing posted", 0, nil, false},
+		{"on the dot", 0, []time.Duration{0}, true},
+		{"a minute late", 0, []time.Duration{time.Minute}, true},
+		{"at the end of the late window", 0, []time.Duration{LateWindow}, true},
+		{"past the late window", 0, []time.Duration{LateWindow + time.Minute}, false},
+		{"grace doesn't stretch the window", 5 * time.Hour, []time.Duration{2 * time.Hour}, false},
+		{"early", 0, []time.Duration{-3 * time.Hour}, true},
+		{"too early", 0, []time.Duration{-EarlyWindow - time.Minute}, false},
+		{"one of several", 0, []time.Duration{-2 * 24 * time.Hour, 10 * time.Minute}, true},
+	}
+
+	for _, c := range cases {
+		s := *sched
+		s.Grace = c.grace
+		published := []time.Time{}
+		for _, d := range c.published {
+			published = append(published, slot.Add(d))
+		}
+		if got := s.Posted(slot, published); got != c.want {
+			t.Errorf("%v: Posted = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestScheduleStreak(t *testing.T) {
+	ny := mustZone(t, "America/New_York")
+	sched := &Schedule{Days: []time.Weekday{time.Monday, time.Thursday}, Hour: 9, Minute: 0, Zone: "America/New_York"}
+
+	published := []time.Time{
+		time.Date(2024, 1, 29, 9, 5, 0, 0, ny), // Mon, on time.
+		time.Date(2024, 2, 1, 15, 0, 0, 0, ny), // Thu, late.
+		time.Date(2024, 2, 5, 8, 0, 0, 0, ny),  // Mon, early.
+		time.Date(2024, 2, 8, 9, 30, 0, 0, ny), // Thu, on time.
+		time.Date(2024, 2, 12, 9, 0, 0, 0, ny), // Mon, on time.
+	}
+	if n := sched.streak(time.Date(2024, 2, 12, 10, 0, 0, 0, ny), published); n != 3 {
+		t.Errorf("streak = %v, want 3", n)
+	}
+	if n := sched.streak(time.Date(2024, 2, 15, 12, 0, 0, 0, ny), published); n != 0 {
+		t.Errorf("streak after a missed slot = %v, want 0", n)
+	}
+}
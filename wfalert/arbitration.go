@@ -0,0 +1,123 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "encoding/json"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// The arbitration data is known to be broken at times, missing fields, bogus dates far in the future,
+// that sort of thing. Anything we can't make sense of is left zero and shown as unknown.
+type ArbitrationData struct {
+	ID         string    `json:"id"`
+	Activation flakyTime `json:"activation"`
+	Expiry     flakyTime `json:"expiry"`
+	Node       string    `json:"node"`
+	Type       string    `json:"type"`
+	Enemy      string    `json:"enemy"`
+	Expired    bool      `json:"expired"`
+}
+
+// flakyTime is a time that never fails to decode, it is zero if the value is missing or nonsense.
+type flakyTime struct {
+	time.Time
+}
+
+func (t *flakyTime) UnmarshalJSON(b []byte) error {
+	s := ""
+	if json.Unmarshal(b, &s) != nil {
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+
+	// Arbitrations only last an hour, so anything more than a day out is junk.
+	if v.Year() < 2000 || v.After(time.Now().Add(24*time.Hour)) {
+		return nil
+	}
+	t.Time = v
+	return nil
+}
+
+func (a *ArbitrationData) known() bool {
+	return a.ID != "" || !a.Activation.IsZero()
+}
+
+func (a *ArbitrationData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{}
+
+	color := 0x00ff00 // Assume green (AKA, "currently running").
+
+	// If it hasn't started yet, add a field with the time till start.
+	if a.Activation.After(time.Now()) {
+		color = 0x0000ff
+		fields = append(fields, style.timeField("Starting in:", "Starts:", a.Activation.Time, formatDuration(a.Activation.Sub(time.Now()))))
+	}
+
+	// If it has expired change the color to red, otherwise add a field with the time until it ends.
+	remaining := "unknown"
+	if !a.Expiry.IsZero() {
+		remaining = style.at(a.Expiry.Time, formatDuration(a.Expiry.Sub(time.Now())))
+	}
+	if a.Expired || !a.Expiry.IsZero() && a.Expiry.Sub(time.Now()).Round(time.Minute) < time.Minute {
+		color = 0xff0000
+	} else {
+		field := style.timeField("Remaining Time:", "Ends:", a.Expiry.Time, remaining)
+		field.Value = remaining
+		fields = append(fields, field)
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:       color, // Blue when not started, Green while running, Red when finished.
+		Title:       "Arbitration:",
+		Description: a.String(),
+		Fields:      fields,
+	}
+}
+
+func (a *ArbitrationData) String() string {
+	return orDefault(a.Type, "unknown") + " at " + orDefault(a.Node, "unknown") + " (" + orDefault(a.Enemy, "unknown") + ")"
+}
+
+func (a *ArbitrationData) FilterString() string {
+	return "Arbitration: " + a.String()
+}
+
+func (a *ArbitrationData) FilterFields() FilterFields {
+	return FilterFields{Node: a.Node, Faction: a.Enemy, Type: a.Type}
+}
+
+func (a *ArbitrationData) Transitions() []time.Time {
+	return []time.Time{a.Activation.Time, a.Expiry.Time}
+}
+
+func (a *ArbitrationData) GetID() (string, int) {
+	if a.ID == "" {
+		return fmt.Sprintf("arbitration:%d", a.Activation.Unix()), TypeArbitration
+	}
+	return a.ID, TypeArbitration
+}
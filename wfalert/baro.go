@@ -0,0 +1,186 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// Items Baro hasn't brought in this long get highlighted.
+var BaroRareAfter = 180 * 24 * time.Hour
+
+type VoidTraderData struct {
+	ID         string               `json:"id"`
+	Activation time.Time            `json:"activation"`
+	Expiry     time.Time            `json:"expiry"`
+	Character  string               `json:"character"`
+	Location   string               `json:"location"`
+	Active     bool                 `json:"active"`
+	Inventory  []VoidTraderItemData `json:"inventory"`
+
+	// When each item in the inventory was last brought on an earlier visit. Missing means never.
+	lastSeen map[string]time.Time
+}
+
+type VoidTraderItemData struct {
+	Item    string `json:"item"`
+	Ducats  int    `json:"ducats"`
+	Credits int    `json:"credits"`
+}
+
+// Records this visit's inventory and loads when each item was last seen before it.
+func (a *VoidTraderData) prepare(platform string) error {
+	if !a.Active || len(a.Inventory) == 0 {
+		return nil
+	}
+
+	lastSeen, err := getBaroHistory(platform, a.visit())
+	if err != nil {
+		return err
+	}
+	// With no history at all everything would be "new", so don't bother.
+	if len(lastSeen) > 0 {
+		a.lastSeen = lastSeen
+	}
+
+	for _, item := range a.Inventory {
+		err := addBaroItem(platform, a.visit(), item.Item, a.Activation)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *VoidTraderData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	if !a.arrived() {
+		fields := []*discordgo.MessageEmbedField{}
+		if a.Activation.After(time.Now()) {
+			fields = append(fields, style.timeField("Arriving in:", "Arrives:", a.Activation, formatDuration(a.Activation.Sub(time.Now()))))
+		}
+
+		return &discordgo.MessageEmbed{
+			Color:       0x0000ff,
+			Title:       a.Character + ":",
+			Description: "Arriving at " + a.Location,
+			Fields:      fields,
+		}
+	}
+
+	// Gone, leave the inventory up for reference.
+	if a.Expiry.Sub(time.Now()).Round(time.Minute) < time.Minute {
+		return &discordgo.MessageEmbed{
+			Color:       0xff0000,
+			Title:       a.Character + ": departed",
+			Description: "Was at " + a.Location + "\n" + a.inventory(),
+		}
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:       0x00ff00,
+		Title:       a.Character + ":",
+		Description: "At " + a.Location + "\n" + a.inventory(),
+		Fields: []*discordgo.MessageEmbedField{
+			style.timeField("Remaining Time:", "Ends:", a.Expiry, formatDuration(a.Expiry.Sub(time.Now()))),
+		},
+	}
+}
+
+func (a *VoidTraderData) Transitions() []time.Time {
+	return []time.Time{a.Activation, a.Expiry}
+}
+
+func (a *VoidTraderData) arrived() bool {
+	return a.Active || !a.Activation.After(time.Now())
+}
+
+func (a *VoidTraderData) inventory() string {
+	out := ""
+	for _, item := range a.Inventory {
+		line := fmt.Sprintf("\n**%v**: %v ducats, %v credits", item.Item, item.Ducats, item.Credits)
+		if a.lastSeen != nil {
+			last, ok := a.lastSeen[item.Item]
+			switch {
+			case !ok:
+				line += " (new!)"
+			case a.Activation.Sub(last) > BaroRareAfter:
+				line += fmt.Sprintf(" (not seen in %d days)", a.Activation.Sub(last)/(24*time.Hour))
+			}
+		}
+
+		// Embed descriptions max out at 4096.
+		if len(out)+len(line) > 3900 {
+			out += "\n..."
+			break
+		}
+		out += line
+	}
+	return out
+}
+
+// Baro's countdown and his arrival are separate posts, filters for an item should only match the arrival.
+func (a *VoidTraderData) FilterString() string {
+	if !a.arrived() {
+		return a.Character + " arriving at " + a.Location
+	}
+	names := []string{}
+	for _, item := range a.Inventory {
+		names = append(names, item.Item)
+	}
+	return a.Character + " at " + a.Location + ": " + strings.Join(names, ", ")
+}
+
+// The API's ID isn't guaranteed to change between visits, so add the arrival time.
+func (a *VoidTraderData) visit() string {
+	return fmt.Sprintf("%v:%d", a.ID, a.Activation.Unix())
+}
+
+func (a *VoidTraderData) FilterFields() FilterFields {
+	items := []string{}
+	for _, item := range a.Inventory {
+		items = append(items, item.Item)
+	}
+	return FilterFields{Reward: strings.Join(items, ", "), Node: a.Location}
+}
+
+func (a *VoidTraderData) GetID() (string, int) {
+	if !a.arrived() {
+		return a.visit() + ":upcoming", TypeVoidTrader
+	}
+	return a.visit() + ":arrived", TypeVoidTrader
+}
+
+// Minute counts are fine for alerts, but Baro's countdown is in days.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days, hours, mins := d/(24*time.Hour), (d%(24*time.Hour))/time.Hour, (d%time.Hour)/time.Minute
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, mins)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, mins)
+	}
+	return fmt.Sprintf("%dm", mins)
+}
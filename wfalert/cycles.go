@@ -0,0 +1,300 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "sync"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// CycleData is one of the open world cycle sections of the worldstate.
+type CycleData struct {
+	ID         string    `json:"id"`
+	Activation time.Time `json:"activation"`
+	Expiry     time.Time `json:"expiry"`
+	State      string    `json:"state"`
+}
+
+type cycleState struct {
+	Name   string
+	Length time.Duration
+}
+
+// A cycle's states, in order. After the last comes the first again.
+type cycleDef struct {
+	Name   string // What users type, "cetus" and the like.
+	Title  string
+	States []cycleState
+}
+
+var Cycles = []cycleDef{
+	{"cetus", "Cetus", []cycleState{{"day", 100 * time.Minute}, {"night", 50 * time.Minute}}},
+	{"vallis", "Orb Vallis", []cycleState{{"warm", 400 * time.Second}, {"cold", 1200 * time.Second}}},
+	{"cambion", "Cambion Drift", []cycleState{{"fass", 100 * time.Minute}, {"vome", 50 * time.Minute}}},
+	{"zariman", "Zariman", []cycleState{{"corpus", 150 * time.Minute}, {"grineer", 150 * time.Minute}}},
+}
+
+func getCycleDef(name string) *cycleDef {
+	for i := range Cycles {
+		if Cycles[i].Name == name {
+			return &Cycles[i]
+		}
+	}
+	return nil
+}
+
+func (c *cycleDef) stateIndex(state string) int {
+	for i, s := range c.States {
+		if s.Name == state {
+			return i
+		}
+	}
+	return -1
+}
+
+// The last state and expiry the API told us about. Everything else is worked out from that.
+type cycleTimer struct {
+	State  string
+	Expiry time.Time
+}
+
+// Returns the state at t, and when it ends.
+func (c cycleTimer) At(def *cycleDef, t time.Time) (string, time.Time) {
+	i := def.stateIndex(c.State)
+	if i < 0 {
+		return c.State, c.Expiry
+	}
+
+	expiry := c.Expiry
+	for !expiry.After(t) {
+		i = (i + 1) % len(def.States)
+		expiry = expiry.Add(def.States[i].Length)
+	}
+	return def.States[i].Name, expiry
+}
+
+// Returns when the given state next starts after t. If it is the current state, that is the start of the next one.
+func (c cycleTimer) NextStart(def *cycleDef, state string, t time.Time) time.Time {
+	current, expiry := c.At(def, t)
+	i := def.stateIndex(current)
+	if i < 0 || def.stateIndex(state) < 0 {
+		return time.Time{}
+	}
+
+	for {
+		i = (i + 1) % len(def.States)
+		if def.States[i].Name == state {
+			return expiry
+		}
+		expiry = expiry.Add(def.States[i].Length)
+	}
+}
+
+// Last known timers, by platform then cycle name.
+var knownCycles = struct {
+	sync.Mutex
+	timers map[string]map[string]cycleTimer
+}{timers: map[string]map[string]cycleTimer{}}
+
+// Remembers the cycles from a fresh worldstate.
+func (ws *WorldState) storeCycles(platform string) {
+	sections := map[string]*CycleData{
+		"cetus":   ws.CetusCycle,
+		"vallis":  ws.VallisCycle,
+		"cambion": ws.CambionCycle,
+		"zariman": ws.ZarimanCycle,
+	}
+
+	knownCycles.Lock()
+	defer knownCycles.Unlock()
+
+	timers, ok := knownCycles.timers[platform]
+	if !ok {
+		timers = map[string]cycleTimer{}
+		knownCycles.timers[platform] = timers
+	}
+	for name, data := range sections {
+		if data == nil || data.State == "" || data.Expiry.IsZero() {
+			continue
+		}
+		timers[name] = cycleTimer{strings.ToLower(data.State), data.Expiry}
+	}
+}
+
+func getCycleTimers(platform string) map[string]cycleTimer {
+	knownCycles.Lock()
+	defer knownCycles.Unlock()
+
+	out := map[string]cycleTimer{}
+	for k, v := range knownCycles.timers[platform] {
+		out[k] = v
+	}
+	return out
+}
+
+// Nothing in the embed changes until a cycle does (or a minute passes, for the countdown style), so edits
+// only happen when there is something new to show.
+func cyclesEmbed(platform string, style EmbedStyle, now time.Time) *discordgo.MessageEmbed {
+	timers := getCycleTimers(platform)
+
+	fields := []*discordgo.MessageEmbedField{}
+	for i := range Cycles {
+		def := &Cycles[i]
+		timer, ok := timers[def.Name]
+		if !ok {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: def.Title + ":", Value: "Unknown", Inline: true})
+			continue
+		}
+
+		state, expiry := timer.At(def, now)
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   def.Title + ":",
+			Value:  strings.Title(state) + ", " + cycleEnds(style, expiry, now),
+			Inline: true,
+		})
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:  0x808080,
+		Title:  "Open World Cycles (" + platform + "):",
+		Fields: fields,
+	}
+}
+
+func cycleEnds(style EmbedStyle, expiry, now time.Time) string {
+	if style == StyleCountdown {
+		return formatDuration(expiry.Sub(now)) + " left"
+	}
+	return "ends " + style.at(expiry, "")
+}
+
+// Edits every cycle status message, posting (and pinning) a new one if the old one is gone.
+func updateCycleMessages(out *Outbox, styles map[string]EmbedStyle, now time.Time) {
+	messages, err := getCycleMessages()
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	for _, m := range messages {
+		m := m
+		embed := cyclesEmbed(m.Platform, styles[m.Guild], now)
+		if m.MID != "" && !embedChanged(m.MID, embed) {
+			continue
+		}
+		out.Add(PriorityEdit, m.CID, discordgo.EndpointChannelMessage(m.CID, ""), func() {
+			if m.MID != "" {
+				_, err := out.s.ChannelMessageEditEmbed(m.CID, m.MID, embed)
+				if err == nil {
+					return
+				}
+				fmt.Println("Error editing message to:", m.MID, err)
+				forgetEmbed(m.MID)
+			}
+			postCycleMessage(out.s, m.Guild, m.CID, m.Platform, embed)
+		})
+	}
+}
+
+func postCycleMessage(s *discordgo.Session, guild, cid, platform string, embed *discordgo.MessageEmbed) {
+	mdat, err := s.ChannelMessageSendEmbed(cid, embed)
+	if err != nil {
+		fmt.Println("Error sending message to:", cid, err)
+		return
+	}
+	embedChanged(mdat.ID, embed)
+	err = setCycleMessage(guild, cid, mdat.ID, platform)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+	}
+
+	// Needs Manage Messages, no big deal if we don't have it.
+	_ = s.ChannelMessagePin(cid, mdat.ID)
+}
+
+// DMs anyone who asked to be told before a cycle changes.
+func checkCycleAlerts(out *Outbox, platform string, now time.Time) {
+	alerts, err := getCycleAlerts("")
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	timers := getCycleTimers(platform)
+
+	for _, alert := range alerts {
+		if alert.Platform != platform {
+			continue
+		}
+		def := getCycleDef(alert.Cycle)
+		timer, ok := timers[alert.Cycle]
+		if def == nil || !ok {
+			continue
+		}
+
+		// The window has to be wider than the gap between polls or a short lead could fall between two of them.
+		// Older alerts may have a lead of 0, which this also covers.
+		window := time.Duration(alert.Lead) * time.Minute
+		if window < 2*PollInterval {
+			window = 2 * PollInterval
+		}
+
+		start := timer.NextStart(def, alert.State, now)
+		if start.IsZero() || start.Sub(now) > window || start.Unix() == alert.Notified {
+			continue
+		}
+
+		err := setCycleAlertNotified(alert, start.Unix())
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			continue
+		}
+
+		uid := alert.UID
+		msg := fmt.Sprintf("%v %v starts in %v (%v).", def.Title, alert.State, formatDuration(start.Sub(now)), platform)
+		out.Add(PrioritySend, "dm:"+uid, discordgo.EndpointUserChannels(""), func() {
+			ch, err := out.s.UserChannelCreate(uid)
+			if err != nil {
+				fmt.Println("Error creating DM channel for:", uid, err)
+				return
+			}
+			_, err = out.s.ChannelMessageSend(ch.ID, msg)
+			if err != nil {
+				fmt.Println("Error sending message to:", ch.ID, err)
+			}
+		})
+	}
+}
+
+func cycleNames() string {
+	names := []string{}
+	for _, c := range Cycles {
+		states := []string{}
+		for _, s := range c.States {
+			states = append(states, s.Name)
+		}
+		names = append(names, c.Name+" ("+strings.Join(states, "/")+")")
+	}
+	return strings.Join(names, ", ")
+}
@@ -23,22 +23,76 @@ misrepresented as being the original software.
 package main
 
 import "strings"
+import "time"
 
 import _ "github.com/mattn/go-sqlite3"
 import "database/sql"
 
 var DB *sql.DB
 
+// Where the database lives, tests point this somewhere temporary.
+var DBPath = "file:wfalert.db"
+
 var InitCode = `
 create table if not exists Channels (
 	ID text
 );
 
+create table if not exists GuildSettings (
+	Guild text primary key,
+	Prefix text,
+	Platform text,
+	Language text,
+	AdminRole text
+);
+
+create table if not exists ChannelFilters (
+	Channel text,
+	Filter text
+);
+
+create table if not exists ChannelMentions (
+	Channel text,
+	Role text,
+	Filter text
+);
+
 create table if not exists UserFilters (
 	User text,
 	Filter text
 );
 
+create table if not exists BaroInventory (
+	Platform text,
+	Visit text,
+	Item text,
+	Seen integer,
+
+	primary key (Platform, Visit, Item)
+);
+
+create table if not exists CycleMessages (
+	CID text primary key,
+	MID text,
+	Platform text
+);
+
+create table if not exists CycleAlerts (
+	User text,
+	Platform text,
+	Cycle text,
+	State text,
+	Lead integer,
+	Notified integer default 0
+);
+
+create table if not exists NightwaveChallenges (
+	Platform text,
+	ID text,
+
+	primary key (Platform, ID)
+);
+
 create table if not exists Messages (
 	MID text,
 	CID text,
@@ -47,56 +101,328 @@ create table if not exists Messages (
 );
 `
 
+// Changes to tables that already existed. Each is run once, errors from a column that is already there are ignored.
+var Migrations = []string{
+	`alter table Channels add column Platform text default 'pc';`,
+	`alter table UserFilters add column Platform text default 'pc';`,
+	`alter table Messages add column Platform text default 'pc';`,
+	`alter table Channels add column Guild text default '';`,
+	`alter table UserFilters add column Guild text default '';`,
+	`alter table Messages add column Guild text default '';`,
+	`alter table CycleMessages add column Guild text default '';`,
+	`alter table CycleAlerts add column Guild text default '';`,
+	`alter table GuildSettings add column Style text default 'relative';`,
+	`alter table GuildSettings add column Language text default 'en';`,
+}
+
 var Queries = map[string]*queryHolder{
-	"ChannelInsert": &queryHolder{`insert into Channels (ID) values (?);`, nil},
+	"SettingsGet":    &queryHolder{`select Prefix, Platform, Language, AdminRole, Style from GuildSettings where Guild = ?;`, nil},
+	"SettingsSet":    &queryHolder{`insert or replace into GuildSettings (Guild, Prefix, Platform, Language, AdminRole, Style) values (?, ?, ?, ?, ?, ?);`, nil},
+	"SettingsStyles": &queryHolder{`select Guild, Style from GuildSettings;`, nil},
+
+	"ChannelInsert": &queryHolder{`insert into Channels (ID, Guild, Platform) values (?, ?, ?);`, nil},
 	"ChannelRemove": &queryHolder{`delete from Channels where ID = ?;`, nil},
-	"ChannelList":   &queryHolder{`select ID from Channels;`, nil},
+	"ChannelList":   &queryHolder{`select ID, Guild, Platform from Channels;`, nil},
+
+	// Channels from before things were scoped to guilds.
+	"UnscopedChannels":     &queryHolder{`select ID from Channels where Guild = '' union select CID from CycleMessages where Guild = '';`, nil},
+	"ChannelSetGuild":      &queryHolder{`update Channels set Guild = ?1 where ID = ?2;`, nil},
+	"CycleMessageSetGuild": &queryHolder{`update CycleMessages set Guild = ?1 where CID = ?2;`, nil},
+	"MessageSetGuild":      &queryHolder{`update Messages set Guild = ?1 where CID = ?2;`, nil},
+	"UnscopedUsers":        &queryHolder{`select User from UserFilters where Guild = '' union select User from CycleAlerts where Guild = '';`, nil},
+	"FilterSetGuild":       &queryHolder{`update UserFilters set Guild = ?1 where User = ?2 and Guild = '';`, nil},
+	"CycleAlertSetGuild":   &queryHolder{`update CycleAlerts set Guild = ?1 where User = ?2 and Guild = '';`, nil},
+
+	"ChannelFilterInsert": &queryHolder{`insert into ChannelFilters (Channel, Filter) values (?, ?);`, nil},
+	"ChannelFilterRemove": &queryHolder{`delete from ChannelFilters where Channel = ? and lower(Filter) = lower(?);`, nil},
+	"ChannelFilterClear":  &queryHolder{`delete from ChannelFilters where Channel = ?;`, nil},
+	"ChannelFilterList":   &queryHolder{`select Channel, Filter from ChannelFilters;`, nil},
+
+	"ChannelMentionInsert": &queryHolder{`insert into ChannelMentions (Channel, Role, Filter) values (?, ?, ?);`, nil},
+	"ChannelMentionRemove": &queryHolder{`delete from ChannelMentions where Channel = ? and Role = ? and (?3 = "" or lower(Filter) = lower(?3));`, nil},
+	"ChannelMentionClear":  &queryHolder{`delete from ChannelMentions where Channel = ?;`, nil},
+	"ChannelMentionList":   &queryHolder{`select Channel, Role, Filter from ChannelMentions;`, nil},
+
+	"FilterInsert": &queryHolder{`insert into UserFilters (User, Guild, Filter, Platform) values (?, ?, ?, ?);`, nil},
+	"FilterRemove": &queryHolder{`delete from UserFilters where (User = ?1 and (Guild = ?2 or Guild = '') and lower(Filter) = lower(?3) and (?4 = "" or Platform = ?4));`, nil},
+	"FilterList":   &queryHolder{`select User, Guild, Filter, Platform from UserFilters where (?1 = "" or User = ?1);`, nil},
+
+	"BaroInsert":  &queryHolder{`insert or ignore into BaroInventory (Platform, Visit, Item, Seen) values (?, ?, ?, ?);`, nil},
+	"BaroHistory": &queryHolder{`select Item, max(Seen) from BaroInventory where Platform = ? and Visit != ? group by Item;`, nil},
+
+	"CycleMessageSet":    &queryHolder{`insert or replace into CycleMessages (CID, MID, Guild, Platform) values (?, ?, ?, ?);`, nil},
+	"CycleMessageRemove": &queryHolder{`delete from CycleMessages where CID = ?;`, nil},
+	"CycleMessageList":   &queryHolder{`select CID, MID, Guild, Platform from CycleMessages;`, nil},
+
+	"CycleAlertInsert":   &queryHolder{`insert into CycleAlerts (User, Guild, Platform, Cycle, State, Lead) values (?, ?, ?, ?, ?, ?);`, nil},
+	"CycleAlertRemove":   &queryHolder{`delete from CycleAlerts where User = ?1 and (Guild = ?2 or Guild = '') and Cycle = ?3 and State = ?4;`, nil},
+	"CycleAlertList":     &queryHolder{`select User, Guild, Platform, Cycle, State, Lead, Notified from CycleAlerts where (?1 = "" or User = ?1);`, nil},
+	"CycleAlertNotified": &queryHolder{`update CycleAlerts set Notified = ? where User = ? and Guild = ? and Platform = ? and Cycle = ? and State = ?;`, nil},
+
+	"ChallengeInsert": &queryHolder{`insert or ignore into NightwaveChallenges (Platform, ID) values (?, ?);`, nil},
+	"ChallengeList":   &queryHolder{`select ID from NightwaveChallenges where Platform = ?;`, nil},
+
+	"MessageInsert": &queryHolder{`insert into Messages (Guild, CID, MID, AID, Typ, Platform) values (?, ?, ?, ?, ?, ?);`, nil},
+	"MessageRemove": &queryHolder{`delete from Messages where AID = ? and Typ = ? and Platform = ?;`, nil},
+	"MessageList":   &queryHolder{`select Guild, CID, MID, AID, Typ, Platform from Messages;`, nil},
+}
 
-	"FilterInsert": &queryHolder{`insert into UserFilters (User, Filter) values (?, ?);`, nil},
-	"FilterRemove": &queryHolder{`delete from UserFilters where (User = ? and Filter = ?);`, nil},
-	"FilterList":   &queryHolder{`select User, Filter from UserFilters where (?1 = "" or User = ?1);`, nil},
+// Guild settings, with the defaults filled in for anything not set.
+type guildSettings struct {
+	Guild     string
+	Prefix    string
+	Platform  string
+	Language  string
+	AdminRole string // Empty for none.
+	Style     string // One of the StyleNames.
+}
 
-	"MessageInsert": &queryHolder{`insert into Messages (CID, MID, AID, Typ) values (?, ?, ?, ?);`, nil},
-	"MessageRemove": &queryHolder{`delete from Messages where AID = ? and Typ = ?;`, nil},
-	"MessageList":   &queryHolder{`select CID, MID, AID, Typ from Messages;`, nil},
+func getGuildSettings(guild string) (guildSettings, error) {
+	gs := guildSettings{Guild: guild, Prefix: "&", Platform: "pc", Language: "en", Style: "relative"}
+	if guild == "" {
+		return gs, nil
+	}
+
+	err := Queries["SettingsGet"].Preped.QueryRow(guild).Scan(&gs.Prefix, &gs.Platform, &gs.Language, &gs.AdminRole, &gs.Style)
+	if err == sql.ErrNoRows {
+		return gs, nil
+	}
+	return gs, err
 }
 
-func addChannel(id string) error {
-	_, err := Queries["ChannelInsert"].Preped.Exec(id)
+func setGuildSettings(gs guildSettings) error {
+	_, err := Queries["SettingsSet"].Preped.Exec(gs.Guild, gs.Prefix, gs.Platform, gs.Language, gs.AdminRole, gs.Style)
 	return err
 }
 
+// The embed style for every guild that has settings, the rest get the default.
+func getGuildStyles() (map[string]EmbedStyle, error) {
+	rows, err := Queries["SettingsStyles"].Preped.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	styles := map[string]EmbedStyle{}
+	for rows.Next() {
+		guild, style := "", ""
+		err := rows.Scan(&guild, &style)
+		if err != nil {
+			return nil, err
+		}
+		styles[guild] = parseStyle(style)
+	}
+	return styles, nil
+}
+
+// A channel only gets one platform, so this replaces any existing entry.
+func addChannel(id, guild, platform string) error {
+	_, err := Queries["ChannelRemove"].Preped.Exec(id)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["ChannelInsert"].Preped.Exec(id, guild, platform)
+	return err
+}
+
+func getUnscopedChannels() ([]string, error) {
+	rows, err := Queries["UnscopedChannels"].Preped.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		id := ""
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Sets the guild for everything attached to the given channel.
+func setChannelGuild(cid, guild string) error {
+	for _, q := range []string{"ChannelSetGuild", "CycleMessageSetGuild", "MessageSetGuild"} {
+		_, err := Queries[q].Preped.Exec(guild, cid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Users with filters or cycle alerts from before those were per guild.
+func getUnscopedUsers() ([]string, error) {
+	rows, err := Queries["UnscopedUsers"].Preped.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		id := ""
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Sets the guild for the user's filters and cycle alerts that don't have one yet.
+func setUserGuild(uid, guild string) error {
+	for _, q := range []string{"FilterSetGuild", "CycleAlertSetGuild"} {
+		_, err := Queries[q].Preped.Exec(guild, uid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Also drops the channel's filters and mentions.
 func removeChannel(id string) error {
 	_, err := Queries["ChannelRemove"].Preped.Exec(id)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["ChannelFilterClear"].Preped.Exec(id)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["ChannelMentionClear"].Preped.Exec(id)
 	return err
 }
 
-func getChannels() ([]string, error) {
+func getChannels() ([]postChannel, error) {
 	rows, err := Queries["ChannelList"].Preped.Query()
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	channels := []string{}
+	channels := []postChannel{}
 	for rows.Next() {
-		f := new(string)
-		err := rows.Scan(f)
+		f := postChannel{}
+		err := rows.Scan(&f.ID, &f.Guild, &f.Platform)
 		if err != nil {
 			return nil, err
 		}
-		channels = append(channels, *f)
+		channels = append(channels, f)
+	}
+
+	filters, err := getChannelFilters()
+	if err != nil {
+		return nil, err
+	}
+	mentions, err := getChannelMentions()
+	if err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		channels[i].Filters = filters[channels[i].ID]
+		for _, filter := range channels[i].Filters {
+			channels[i].exprs = append(channels[i].exprs, loadFilter(filter))
+		}
+		channels[i].Mentions = mentions[channels[i].ID]
 	}
 	return channels, nil
 }
 
-func addFilter(uid, filter string) error {
-	_, err := Queries["FilterInsert"].Preped.Exec(uid, strings.ToLower(filter))
+type postChannel struct {
+	ID       string
+	Guild    string
+	Platform string
+
+	// If there are any filters the channel only gets items matching at least one.
+	Filters  []string
+	Mentions []roleMention
+
+	exprs []filterExpr // Parsed Filters.
+}
+
+type roleMention struct {
+	Role   string
+	Filter string
+
+	expr filterExpr // Parsed Filter.
+}
+
+func addChannelFilter(cid, filter string) error {
+	_, err := Queries["ChannelFilterInsert"].Preped.Exec(cid, filter)
+	return err
+}
+
+func removeChannelFilter(cid, filter string) error {
+	_, err := Queries["ChannelFilterRemove"].Preped.Exec(cid, filter)
+	return err
+}
+
+func getChannelFilters() (map[string][]string, error) {
+	rows, err := Queries["ChannelFilterList"].Preped.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := map[string][]string{}
+	for rows.Next() {
+		cid, filter := "", ""
+		err := rows.Scan(&cid, &filter)
+		if err != nil {
+			return nil, err
+		}
+		filters[cid] = append(filters[cid], filter)
+	}
+	return filters, nil
+}
+
+func addChannelMention(cid, role, filter string) error {
+	_, err := Queries["ChannelMentionInsert"].Preped.Exec(cid, role, filter)
 	return err
 }
 
-func removeFilter(uid, filter string) error {
-	_, err := Queries["FilterRemove"].Preped.Exec(uid, strings.ToLower(filter))
+// Pass "" as the filter to remove every rule for the role.
+func removeChannelMention(cid, role, filter string) error {
+	_, err := Queries["ChannelMentionRemove"].Preped.Exec(cid, role, filter)
+	return err
+}
+
+func getChannelMentions() (map[string][]roleMention, error) {
+	rows, err := Queries["ChannelMentionList"].Preped.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := map[string][]roleMention{}
+	for rows.Next() {
+		cid, m := "", roleMention{}
+		err := rows.Scan(&cid, &m.Role, &m.Filter)
+		if err != nil {
+			return nil, err
+		}
+		m.expr = loadFilter(m.Filter)
+		mentions[cid] = append(mentions[cid], m)
+	}
+	return mentions, nil
+}
+
+func addFilter(uid, guild, filter, platform string) error {
+	_, err := Queries["FilterInsert"].Preped.Exec(uid, guild, filter, platform)
+	return err
+}
+
+// Pass "" as the platform to remove the filter for all platforms. Filters with no guild (from before
+// filters were per guild) can be removed from any guild.
+func removeFilter(uid, guild, filter, platform string) error {
+	_, err := Queries["FilterRemove"].Preped.Exec(uid, guild, filter, platform)
 	return err
 }
 
@@ -111,71 +437,207 @@ func getFilters(uid string) ([]userFilter, error) {
 	filters := []userFilter{}
 	for rows.Next() {
 		f := userFilter{}
-		err := rows.Scan(&f.UID, &f.Filter)
+		err := rows.Scan(&f.UID, &f.Guild, &f.Filter, &f.Platform)
 		if err != nil {
 			return nil, err
 		}
+		f.expr = loadFilter(f.Filter)
 		filters = append(filters, f)
 	}
 	return filters, nil
 }
 
-type userFilter struct {
-	UID    string
-	Filter string
+func addBaroItem(platform, visit, item string, seen time.Time) error {
+	_, err := Queries["BaroInsert"].Preped.Exec(platform, visit, item, seen.Unix())
+	return err
 }
 
-func addMessage(cid, mid, aid string, alert bool) error {
-	t := 0
-	if alert {
-		t = 1
+// When each item was last brought, not counting the given visit.
+func getBaroHistory(platform, visit string) (map[string]time.Time, error) {
+	rows, err := Queries["BaroHistory"].Preped.Query(platform, visit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]time.Time{}
+	for rows.Next() {
+		item, t := "", int64(0)
+		err := rows.Scan(&item, &t)
+		if err != nil {
+			return nil, err
+		}
+		seen[item] = time.Unix(t, 0)
 	}
-	_, err := Queries["MessageInsert"].Preped.Exec(cid, mid, aid, t)
+	return seen, nil
+}
+
+func setCycleMessage(guild, cid, mid, platform string) error {
+	_, err := Queries["CycleMessageSet"].Preped.Exec(cid, mid, guild, platform)
+	return err
+}
+
+func removeCycleMessage(cid string) error {
+	_, err := Queries["CycleMessageRemove"].Preped.Exec(cid)
 	return err
 }
 
-func removeMessage(aid string, alert bool) error {
-	t := 0
-	if alert {
-		t = 1
+type cycleMessage struct {
+	CID      string
+	MID      string
+	Guild    string
+	Platform string
+}
+
+func getCycleMessages() ([]cycleMessage, error) {
+	rows, err := Queries["CycleMessageList"].Preped.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []cycleMessage{}
+	for rows.Next() {
+		f := cycleMessage{}
+		err := rows.Scan(&f.CID, &f.MID, &f.Guild, &f.Platform)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, f)
 	}
-	_, err := Queries["MessageRemove"].Preped.Exec(aid, t)
+	return messages, nil
+}
+
+type cycleAlert struct {
+	UID      string
+	Guild    string // Empty for alerts from before alerts were per guild.
+	Platform string
+	Cycle    string
+	State    string
+	Lead     int   // Minutes.
+	Notified int64 // Start time (unix) of the last transition we sent a DM for.
+}
+
+// Replaces any existing alert for the same cycle state.
+func addCycleAlert(a cycleAlert) error {
+	_, err := Queries["CycleAlertRemove"].Preped.Exec(a.UID, a.Guild, a.Cycle, a.State)
+	if err != nil {
+		return err
+	}
+	_, err = Queries["CycleAlertInsert"].Preped.Exec(a.UID, a.Guild, a.Platform, a.Cycle, a.State, a.Lead)
+	return err
+}
+
+func removeCycleAlert(uid, guild, cycle, state string) error {
+	_, err := Queries["CycleAlertRemove"].Preped.Exec(uid, guild, cycle, state)
+	return err
+}
+
+// Pass "" as the UID to list all.
+func getCycleAlerts(uid string) ([]cycleAlert, error) {
+	rows, err := Queries["CycleAlertList"].Preped.Query(uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []cycleAlert{}
+	for rows.Next() {
+		f := cycleAlert{}
+		err := rows.Scan(&f.UID, &f.Guild, &f.Platform, &f.Cycle, &f.State, &f.Lead, &f.Notified)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, f)
+	}
+	return alerts, nil
+}
+
+func setCycleAlertNotified(a cycleAlert, start int64) error {
+	_, err := Queries["CycleAlertNotified"].Preped.Exec(start, a.UID, a.Guild, a.Platform, a.Cycle, a.State)
+	return err
+}
+
+func addSeenChallenge(platform, id string) error {
+	_, err := Queries["ChallengeInsert"].Preped.Exec(platform, id)
+	return err
+}
+
+func getSeenChallenges(platform string) (map[string]bool, error) {
+	rows, err := Queries["ChallengeList"].Preped.Query(platform)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	for rows.Next() {
+		id := ""
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+	}
+	return seen, nil
+}
+
+type userFilter struct {
+	UID      string
+	Guild    string // Empty for filters from before filters were per guild.
+	Filter   string
+	Platform string
+
+	expr filterExpr // Parsed Filter.
+}
+
+func addMessage(guild, cid, mid string, key messageKey) error {
+	_, err := Queries["MessageInsert"].Preped.Exec(guild, cid, mid, key.AID, key.Typ, key.Platform)
 	return err
 }
 
-func getMessages() ([2]map[string][]event, error) {
+func removeMessage(key messageKey) error {
+	_, err := Queries["MessageRemove"].Preped.Exec(key.AID, key.Typ, key.Platform)
+	return err
+}
+
+// Identifies the item a message is for. Item IDs are only unique within a platform and type.
+type messageKey struct {
+	Platform string
+	AID      string
+	Typ      int
+}
+
+func getMessages() (map[messageKey][]event, error) {
 	rows, err := Queries["MessageList"].Preped.Query()
 	if err != nil {
-		return [2]map[string][]event{}, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	events := [2]map[string][]event{map[string][]event{}, map[string][]event{}}
+	events := map[messageKey][]event{}
 	for rows.Next() {
-		typ, aid := 0, ""
+		key := messageKey{}
 		f := event{}
-		err := rows.Scan(&f.CID, &f.MID, &aid, &typ)
+		err := rows.Scan(&f.Guild, &f.CID, &f.MID, &key.AID, &key.Typ, &key.Platform)
 		if err != nil {
-			return [2]map[string][]event{}, err
+			return nil, err
 		}
 
-		// Make sure bad DB data doesn't crash us.
-		if typ != 0 {
-			typ = 1
-		}
-		events[typ][aid] = append(events[typ][aid], f)
+		events[key] = append(events[key], f)
 	}
 	return events, nil
 }
 
 type event struct {
-	CID string
-	MID string
+	Guild string
+	CID   string
+	MID   string
 }
 
-func init() {
+func openDB() {
 	var err error
-	DB, err = sql.Open("sqlite3", "file:wfalert.db")
+	DB, err = sql.Open("sqlite3", DBPath)
 	if err != nil {
 		panic(err)
 	}
@@ -185,6 +647,13 @@ func init() {
 		panic(err)
 	}
 
+	for _, m := range Migrations {
+		_, err := DB.Exec(m)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			panic(err)
+		}
+	}
+
 	for _, v := range Queries {
 		err := v.Init()
 		if err != nil {
@@ -0,0 +1,424 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "regexp"
+import "fmt"
+
+// Filters are small expressions, like `reward:nitain and not kind:invasion` or `node:/^(ceres|mars)/`.
+//
+//	expr  = and {"or" and}
+//	and   = not {["and"] not}
+//	not   = "not" not | "(" expr ")" | term
+//	term  = [field ":"] value
+//	value = word | "phrase" | 'phrase' | /regex/
+//
+// Matching ignores case. Bare words (no field) are a contains-match on the item's FilterString, which for
+// alerts and invasions is the reward, so the plain text filters from before this keep working. Bare words
+// next to each other are one phrase, `axi survival` still means what it always did.
+
+// FilterFields are the things a filter can ask for by name, other than the kind.
+type FilterFields struct {
+	Reward  string
+	Node    string
+	Faction string
+	Type    string
+
+	// Invasion sides, as "Faction: reward", so a filter can ask for a reward from one side only.
+	Attacker string
+	Defender string
+}
+
+var FilterFieldNames = []string{"reward", "node", "faction", "type", "kind", "attacker", "defender"}
+
+// The names for kind: in filters.
+var KindNames = map[int]string{
+	TypeInvasion:    "invasion",
+	TypeAlert:       "alert",
+	TypeFissure:     "fissure",
+	TypeVoidTrader:  "baro",
+	TypeSortie:      "sortie",
+	TypeArchonHunt:  "archon",
+	TypeNightwave:   "nightwave",
+	TypeArbitration: "arbitration",
+	TypeSteelPath:   "steelpath",
+	TypeDuviri:      "duviri",
+}
+
+// filterMatch is what a filter is matched against.
+type filterMatch struct {
+	Text   string
+	Kind   string
+	Fields FilterFields
+}
+
+func itemMatch(item Embedable) *filterMatch {
+	_, typ := item.GetID()
+	return &filterMatch{
+		Text:   item.FilterString(),
+		Kind:   KindNames[typ],
+		Fields: item.FilterFields(),
+	}
+}
+
+func (m *filterMatch) field(name string) string {
+	switch name {
+	case "":
+		return m.Text
+	case "reward":
+		return m.Fields.Reward
+	case "node":
+		return m.Fields.Node
+	case "faction":
+		return m.Fields.Faction
+	case "type":
+		return m.Fields.Type
+	case "attacker":
+		return m.Fields.Attacker
+	case "defender":
+		return m.Fields.Defender
+	case "kind":
+		return m.Kind
+	}
+	return ""
+}
+
+// Parses a stored filter, done once as filters are loaded rather than for every match. Old filters that don't
+// parse are treated as a plain substring.
+func loadFilter(filter string) filterExpr {
+	expr, err := parseFilter(filter)
+	if err != nil {
+		return &filterTerm{Phrase: strings.ToLower(filter)}
+	}
+	return expr
+}
+
+type filterExpr interface {
+	match(m *filterMatch) bool
+}
+
+type filterAnd []filterExpr
+
+func (f filterAnd) match(m *filterMatch) bool {
+	for _, e := range f {
+		if !e.match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+type filterOr []filterExpr
+
+func (f filterOr) match(m *filterMatch) bool {
+	for _, e := range f {
+		if e.match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterNot struct {
+	expr filterExpr
+}
+
+func (f filterNot) match(m *filterMatch) bool {
+	return !f.expr.match(m)
+}
+
+type filterTerm struct {
+	Field  string // "" for bare words.
+	Phrase string // Lower case.
+	Regex  *regexp.Regexp
+	word   bool // Unquoted bare word, these get joined into phrases.
+}
+
+func (f *filterTerm) match(m *filterMatch) bool {
+	v := m.field(f.Field)
+	switch {
+	case f.Regex != nil:
+		return f.Regex.MatchString(v)
+	case f.Field == "kind":
+		return v == f.Phrase
+	default:
+		return strings.Contains(strings.ToLower(v), f.Phrase)
+	}
+}
+
+type filterToken struct {
+	Op   string // "(", ")", "and", "or", "not", or "" for a term.
+	Term *filterTerm
+	At   int
+}
+
+func tokenizeFilter(in string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	i := 0
+	for i < len(in) {
+		switch in[i] {
+		case ' ', '\t', '\n':
+			i++
+			continue
+		case '(', ')':
+			tokens = append(tokens, filterToken{Op: in[i : i+1], At: i})
+			i++
+			continue
+		}
+
+		start := i
+		term := &filterTerm{}
+
+		// An optional field name.
+		if c := strings.IndexByte(in[i:], ':'); c > 0 && isFieldName(in[i:i+c]) {
+			name := strings.ToLower(in[i : i+c])
+			if !isKnownField(name) {
+				return nil, fmt.Errorf("unknown field %q (try %v)", name, strings.Join(FilterFieldNames, ", "))
+			}
+			term.Field = name
+			i += c + 1
+		}
+
+		switch {
+		case i < len(in) && (in[i] == '"' || in[i] == '\''):
+			end := strings.IndexByte(in[i+1:], in[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at %d", i+1)
+			}
+			term.Phrase = strings.ToLower(in[i+1 : i+1+end])
+			i += end + 2
+		case i < len(in) && in[i] == '/':
+			end := i + 1
+			for end < len(in) && in[end] != '/' {
+				if in[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(in) {
+				return nil, fmt.Errorf("unterminated regex at %d", i+1)
+			}
+			re, err := regexp.Compile("(?i)" + strings.ReplaceAll(in[i+1:end], `\/`, "/"))
+			if err != nil {
+				return nil, fmt.Errorf("bad regex at %d: %v", i+1, err)
+			}
+			term.Regex = re
+			i = end + 1
+		default:
+			end := i
+			for end < len(in) && !strings.ContainsRune(" \t\n()", rune(in[end])) {
+				end++
+			}
+			term.Phrase = strings.ToLower(in[i:end])
+			i = end
+
+			if term.Field == "" {
+				switch term.Phrase {
+				case "and", "or", "not":
+					tokens = append(tokens, filterToken{Op: term.Phrase, At: start})
+					continue
+				}
+				term.word = true
+			}
+		}
+
+		if term.Field != "" && term.Regex == nil && term.Phrase == "" {
+			return nil, fmt.Errorf("%v: needs a value", term.Field)
+		}
+		if term.Field == "kind" && term.Regex == nil && !isKindName(term.Phrase) {
+			return nil, fmt.Errorf("unknown kind %q (try %v)", term.Phrase, strings.Join(kindNames(), ", "))
+		}
+		tokens = append(tokens, filterToken{Term: term, At: start})
+	}
+	return tokens, nil
+}
+
+// Parses and validates a filter expression.
+func parseFilter(in string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+
+	p := &filterParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.tokens[p.i].Op, p.tokens[p.i].At+1)
+	}
+	return expr, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	i      int
+}
+
+func (p *filterParser) peek() string {
+	if p.i >= len(p.tokens) {
+		return "end"
+	}
+	return p.tokens[p.i].Op
+}
+
+func (p *filterParser) or() (filterExpr, error) {
+	exprs := filterOr{}
+	for {
+		e, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+
+		if p.peek() != "or" {
+			break
+		}
+		p.i++
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+func (p *filterParser) and() (filterExpr, error) {
+	exprs := filterAnd{}
+	explicit := false
+	for {
+		switch p.peek() {
+		case "and":
+			if len(exprs) == 0 || explicit {
+				return nil, fmt.Errorf("expected something before and at %d", p.tokens[p.i].At+1)
+			}
+			explicit = true
+			p.i++
+			continue
+		case ")", "or", "end":
+			if explicit {
+				return nil, fmt.Errorf("expected something after and")
+			}
+			if len(exprs) == 0 {
+				return nil, fmt.Errorf("expected something before %v", p.peek())
+			}
+			if len(exprs) == 1 {
+				return exprs[0], nil
+			}
+			return exprs, nil
+		}
+
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		// Join runs of bare words back into a phrase.
+		if t, ok := e.(*filterTerm); ok && t.word && !explicit && len(exprs) > 0 {
+			if prev, ok := exprs[len(exprs)-1].(*filterTerm); ok && prev.word {
+				prev.Phrase += " " + t.Phrase
+				continue
+			}
+		}
+		exprs = append(exprs, e)
+		explicit = false
+	}
+}
+
+func (p *filterParser) not() (filterExpr, error) {
+	switch p.peek() {
+	case "not":
+		at := p.tokens[p.i].At
+		p.i++
+		switch p.peek() {
+		case ")", "or", "and", "end":
+			return nil, fmt.Errorf("expected something after the not at %d", at+1)
+		}
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{e}, nil
+	case "(":
+		at := p.tokens[p.i].At
+		p.i++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) for the ( at %d", at+1)
+		}
+		p.i++
+
+		// A group is never part of a phrase, `forma (blueprint)` needs both.
+		if t, ok := e.(*filterTerm); ok {
+			t.word = false
+		}
+		return e, nil
+	case "":
+		t := p.tokens[p.i].Term
+		p.i++
+		return t, nil
+	}
+	return nil, fmt.Errorf("unexpected %v", p.peek())
+}
+
+func isFieldName(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isKnownField(name string) bool {
+	for _, f := range FilterFieldNames {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isKindName(name string) bool {
+	for _, k := range KindNames {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+func kindNames() []string {
+	names := []string{}
+	for typ := 0; typ < len(KindNames); typ++ {
+		names = append(names, KindNames[typ])
+	}
+	return names
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	cases := []struct {
+		in  string
+		err bool
+	}{
+		{"nitain", false},
+		{"axi survival", false},
+		{"reward:nitain and not kind:invasion", false},
+		{"node:/^(ceres|mars)/", false},
+		{`reward:"orokin catalyst" or reward:'orokin reactor'`, false},
+		{"forma (blueprint)", false},
+		{"not (kind:alert or kind:invasion)", false},
+		{"", true},
+		{"   ", true},
+		{"colour:red", true},
+		{"kind:raid", true},
+		{"reward:", true},
+		{`reward:"nitain`, true},
+		{"node:/ceres", true},
+		{"node:/(/", true},
+		{"and nitain", true},
+		{"nitain and", true},
+		{"nitain and and forma", true},
+		{"nitain or", true},
+		{"not", true},
+		{"(nitain", true},
+		{"nitain)", true},
+		{"()", true},
+	}
+
+	for _, c := range cases {
+		_, err := parseFilter(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("parseFilter(%q): got error %v, want error: %v", c.in, err, c.err)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	alert := &filterMatch{
+		Text:   "Nitain Extract (Axi Survival)",
+		Kind:   "alert",
+		Fields: FilterFields{Reward: "Nitain Extract", Node: "Ceres/Gabii", Faction: "Grineer", Type: "Survival"},
+	}
+	invasion := &filterMatch{
+		Text: "Forma Blueprint vs Orokin Reactor",
+		Kind: "invasion",
+		Fields: FilterFields{
+			Reward:   "Forma Blueprint, Orokin Reactor",
+			Node:     "Mars/Ares",
+			Attacker: "Corpus: Forma Blueprint",
+			Defender: "Grineer: Orokin Reactor",
+		},
+	}
+
+	cases := []struct {
+		filter string
+		m      *filterMatch
+		want   bool
+	}{
+		{"nitain", alert, true},
+		{"NITAIN", alert, true},
+		{"forma", alert, false},
+		{"axi survival", alert, true},
+		{"survival axi", alert, false},
+		{"reward:nitain", alert, true},
+		{"node:ceres", alert, true},
+		{"node:mars", alert, false},
+		{"faction:grineer and type:survival", alert, true},
+		{"faction:grineer type:defense", alert, false},
+		{"kind:alert", alert, true},
+		{"kind:invasion", alert, false},
+		{"reward:nitain and not kind:invasion", alert, true},
+		{"reward:nitain and not kind:alert", alert, false},
+		{"node:/^(ceres|mars)/", alert, true},
+		{"node:/^(ceres|mars)/", invasion, true},
+		{"node:/^ares/", invasion, false},
+		{`reward:"orokin reactor"`, invasion, true},
+		{`reward:'nitain extract' or reward:"orokin reactor"`, invasion, true},
+		{"attacker:forma", invasion, true},
+		{"defender:forma", invasion, false},
+		{"defender:grineer", invasion, true},
+		{"forma blueprint", invasion, true},
+		{"forma orokin", invasion, false},
+		{"forma (orokin)", invasion, true},
+		{"(forma) orokin", invasion, true},
+		{"forma (catalyst)", invasion, false},
+		{"not (kind:alert or kind:invasion)", invasion, false},
+		{"not not kind:invasion", invasion, true},
+		{"nitain or forma and not kind:invasion", invasion, false},
+		{"(nitain or forma) and kind:invasion", invasion, true},
+	}
+
+	for _, c := range cases {
+		expr, err := parseFilter(c.filter)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", c.filter, err)
+			continue
+		}
+		if got := expr.match(c.m); got != c.want {
+			t.Errorf("%q against %q: got %v, want %v", c.filter, c.m.Text, got, c.want)
+		}
+	}
+}
+
+// Filters saved before the expression syntax that don't parse still work as a plain substring.
+func TestLoadFilterFallback(t *testing.T) {
+	m := &filterMatch{Text: "Orokin Catalyst (Blueprint)", Kind: "alert"}
+
+	expr := loadFilter("catalyst (blueprint")
+	if !expr.match(m) {
+		t.Errorf("unparsable filter didn't fall back to a substring match")
+	}
+	if loadFilter("reactor (blueprint").match(m) {
+		t.Errorf("unparsable filter matched text it doesn't contain")
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// Languages a guild can pick. Only English so far, the setting is kept so guilds can switch as soon as a
+// translation lands.
+var Languages = map[string]string{
+	"en": "English",
+}
+
+// Fills in the guild for channels set up before everything was scoped to guilds.
+func backfillGuilds(s *discordgo.Session) {
+	ids, err := getUnscopedChannels()
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+
+	for _, id := range ids {
+		ch, err := s.State.Channel(id)
+		if err != nil {
+			ch, err = s.Channel(id)
+		}
+		if err != nil {
+			fmt.Println("Error looking up channel:", id, err)
+			continue
+		}
+		err = setChannelGuild(id, ch.GuildID)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+	}
+
+	// Filters and cycle alerts only say who they are for. If that user is in just one of our guilds they
+	// must belong to it, otherwise they stay unscoped (the user can still list and remove them anywhere).
+	users, err := getUnscopedUsers()
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	if len(users) == 0 {
+		return
+	}
+	guilds, err := s.UserGuilds(100, "", "")
+	if err != nil {
+		fmt.Println("Error listing guilds:", err)
+		return
+	}
+	for _, uid := range users {
+		found := []string{}
+		for _, g := range guilds {
+			if _, err := s.GuildMember(g.ID, uid); err == nil {
+				found = append(found, g.ID)
+			}
+		}
+		if len(found) != 1 {
+			continue
+		}
+		err = setUserGuild(uid, found[0])
+		if err != nil {
+			fmt.Println("DB Error:", err)
+		}
+	}
+}
+
+func hasRole(m *discordgo.Member, role string) bool {
+	if m == nil || role == "" {
+		return false
+	}
+	for _, r := range m.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// &settings and &set
+func settingsCommand(s *discordgo.Session, m *discordgo.MessageCreate, command []string, gs guildSettings, isAdmin bool) {
+	if command[0] == "&settings" {
+		s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content:         describeSettings(gs),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		return
+	}
+
+	if !isAdmin {
+		s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+		return
+	}
+	if len(command) < 3 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `"+gs.Prefix+"set prefix|platform|language|adminrole|style value`")
+		return
+	}
+
+	value := command[2]
+	switch strings.ToLower(command[1]) {
+	case "prefix":
+		if value == "" || strings.ContainsAny(value, " \t\"") {
+			s.ChannelMessageSend(m.ChannelID, "A prefix can't be empty or have spaces or quotes in it.")
+			return
+		}
+		gs.Prefix = value
+	case "platform":
+		value = strings.ToLower(value)
+		if !isPlatform(value) {
+			s.ChannelMessageSend(m.ChannelID, "Unknown platform, try one of: "+strings.Join(Platforms, ", "))
+			return
+		}
+		gs.Platform = value
+	case "language":
+		value = strings.ToLower(value)
+		if _, ok := Languages[value]; !ok {
+			langs := []string{}
+			for k, v := range Languages {
+				langs = append(langs, k+" ("+v+")")
+			}
+			s.ChannelMessageSend(m.ChannelID, "Unknown language, try one of: "+strings.Join(langs, ", "))
+			return
+		}
+		gs.Language = value
+	case "style":
+		value = strings.ToLower(value)
+		if _, ok := StyleNames[value]; !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown style, try relative (times count down on their own) or countdown (minute counts, edited every minute)")
+			return
+		}
+		gs.Style = value
+	case "adminrole":
+		if strings.ToLower(value) == "none" {
+			gs.AdminRole = ""
+			break
+		}
+		role, ok := roleArg(value)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Not a role: "+value)
+			return
+		}
+		gs.AdminRole = role
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Unknown setting, try one of: prefix, platform, language, adminrole, style")
+		return
+	}
+
+	err := setGuildSettings(gs)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+		fmt.Println("Settings error:", err)
+		return
+	}
+	s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content:         "Updated. " + describeSettings(gs),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+}
+
+func describeSettings(gs guildSettings) string {
+	role := "none"
+	if gs.AdminRole != "" {
+		role = "<@&" + gs.AdminRole + ">"
+	}
+	return fmt.Sprintf("Prefix: `%v`, default platform: %v, language: %v, admin role: %v, time style: %v", gs.Prefix, gs.Platform, gs.Language, role, gs.Style)
+}
+
+// &config, everything wfalert has stored for the guild.
+func configCommand(s *discordgo.Session, m *discordgo.MessageCreate, gs guildSettings) {
+	channels, err := getChannels()
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+		fmt.Println("Channel list error:", err)
+		return
+	}
+	cycleMessages, err := getCycleMessages()
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+		fmt.Println("Cycle message list error:", err)
+		return
+	}
+	filters, err := getFilters("")
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+		fmt.Println("Filter list error:", err)
+		return
+	}
+	alerts, err := getCycleAlerts("")
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+		fmt.Println("Cycle alert list error:", err)
+		return
+	}
+	messages, err := getMessages()
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+		fmt.Println("Message list error:", err)
+		return
+	}
+
+	msg := "**Settings:**\n" + describeSettings(gs) + "\n**Posting Channels:**"
+	for _, c := range channels {
+		if c.Guild != gs.Guild {
+			continue
+		}
+		msg += fmt.Sprintf("\n<#%v> (%v)", c.ID, c.Platform)
+		for _, f := range c.Filters {
+			msg += "\n- filter: " + f
+		}
+		for _, mention := range c.Mentions {
+			msg += "\n- mention <@&" + mention.Role + "> for " + mention.Filter
+		}
+	}
+	msg += "\n**Cycle Status Messages:**"
+	for _, c := range cycleMessages {
+		if c.Guild == gs.Guild {
+			msg += fmt.Sprintf("\n<#%v> (%v)", c.CID, c.Platform)
+		}
+	}
+	// Filters and alerts backfillGuilds couldn't give a guild to belong to no guild in particular, so they
+	// aren't shown to anyone's admins.
+	msg += "\n**User Filters:**"
+	for _, f := range filters {
+		if f.Guild == gs.Guild {
+			msg += fmt.Sprintf("\n<@%v>: %v (%v)", f.UID, f.Filter, f.Platform)
+		}
+	}
+	msg += "\n**Cycle Alerts:**"
+	for _, a := range alerts {
+		if a.Guild == gs.Guild {
+			msg += fmt.Sprintf("\n<@%v>: %v %v, %d minutes before (%v)", a.UID, a.Cycle, a.State, a.Lead, a.Platform)
+		}
+	}
+
+	tracked := 0
+	for _, events := range messages {
+		for _, e := range events {
+			if e.Guild == gs.Guild {
+				tracked++
+			}
+		}
+	}
+	msg += fmt.Sprintf("\n**Tracked Messages:** %d", tracked)
+
+	sendLong(s, m.ChannelID, msg)
+}
+
+// Sends a message in as many parts as needed to fit Discord's length limit, without pinging anyone.
+func sendLong(s *discordgo.Session, cid, msg string) {
+	for msg != "" {
+		part := msg
+		if len(part) > 1900 {
+			part = part[:1900]
+			if i := strings.LastIndex(part, "\n"); i > 0 {
+				part = part[:i]
+			}
+		}
+		msg = strings.TrimPrefix(msg[len(part):], "\n")
+
+		_, err := s.ChannelMessageSendComplex(cid, &discordgo.MessageSend{
+			Content:         part,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			fmt.Println("Error sending message to:", cid, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "sync"
+import "time"
+import "math"
+import "fmt"
+
+type factionStyle struct {
+	Color int
+	Icon  string
+}
+
+// Embed colours and icons for each faction. Anything not listed uses the plain running green.
+var Factions = map[string]factionStyle{
+	"grineer":   {0xe67e22, "🟧"},
+	"corpus":    {0x3498db, "🟦"},
+	"infested":  {0x2ecc71, "🟩"},
+	"corrupted": {0xf1c40f, "🟨"},
+}
+
+func getFaction(name string) factionStyle {
+	f, ok := Factions[strings.ToLower(name)]
+	if !ok {
+		return factionStyle{0x00ff00, "⬜"}
+	}
+	return f
+}
+
+// How far back to look when projecting when an invasion will finish.
+var InvasionWindow = 1 * time.Hour
+
+type invasionSample struct {
+	At    time.Time
+	Count int
+}
+
+// Recent progress samples for each invasion, by platform then invasion ID.
+var knownInvasions = struct {
+	sync.Mutex
+	samples map[string]map[string][]invasionSample
+}{samples: map[string]map[string][]invasionSample{}}
+
+// Records each invasion's progress and works out when it should finish from how fast it has been moving.
+func (ws *WorldState) trackInvasions(platform string, now time.Time) {
+	knownInvasions.Lock()
+	defer knownInvasions.Unlock()
+
+	old := knownInvasions.samples[platform]
+	samples := map[string][]invasionSample{}
+	for _, a := range ws.Invasions {
+		if a.Completed || a.RequiredRuns == 0 {
+			continue
+		}
+
+		// Keep one sample from before the window so there is always a full window to measure.
+		s := old[a.ID]
+		for len(s) > 1 && now.Sub(s[1].At) > InvasionWindow {
+			s = s[1:]
+		}
+		s = append(s, invasionSample{now, a.Count})
+		samples[a.ID] = s
+
+		a.projected = projectInvasion(s, a.RequiredRuns, a.VSInfestation)
+	}
+	knownInvasions.samples[platform] = samples
+}
+
+// Count runs from -required (defenders win) to required (attackers win). Against the Infestation only the
+// defenders' runs count, so it starts at 0 and only goes down.
+func projectInvasion(samples []invasionSample, required int, vsInfestation bool) time.Time {
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.At.Sub(first.At)
+	if elapsed < 5*time.Minute || last.Count == first.Count {
+		return time.Time{}
+	}
+
+	rate := float64(last.Count-first.Count) / elapsed.Seconds()
+	if vsInfestation && rate > 0 {
+		return time.Time{}
+	}
+	left := float64(required - last.Count)
+	if rate < 0 {
+		left = float64(-required - last.Count)
+	}
+
+	// Rounded so small changes in the rate don't mean an edit every poll.
+	return last.At.Add(time.Duration(left / rate * float64(time.Second))).Round(5 * time.Minute)
+}
+
+// The attackers' share of the progress, 0 to 1.
+func (a *InvasionData) share() float64 {
+	return math.Max(0, math.Min(1, a.Completion/100))
+}
+
+func (a *InvasionData) progressBar() string {
+	attacker, defender := getFaction(a.Attacker), getFaction(a.Defender)
+	n := int(math.Round(a.share() * 10))
+	return fmt.Sprintf("%v %v%v %v\n%.0f%% %v, %.0f%% %v", a.Attacker, strings.Repeat(attacker.Icon, n), strings.Repeat(defender.Icon, 10-n), a.Defender,
+		a.share()*100, a.Attacker, 100-a.share()*100, a.Defender)
+}
+
+// The faction that is ahead.
+func (a *InvasionData) leader() string {
+	if a.share() >= 0.5 {
+		return a.Attacker
+	}
+	return a.Defender
+}
+
+// What each side is offering, like "Grineer: 3x Fieldron". The Infestation gives nothing.
+func (a *InvasionData) attackerSide() string {
+	if a.VSInfestation {
+		return a.Attacker
+	}
+	return a.Attacker + ": " + a.AttackerReward.Desc
+}
+
+func (a *InvasionData) defenderSide() string {
+	return a.Defender + ": " + a.DefenderReward.Desc
+}
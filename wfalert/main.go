@@ -20,11 +20,12 @@ misrepresented as being the original software.
 3. This notice may not be removed or altered from any source distribution.
 */
 
-//
+// WFAlert: Warframe alert and invasion Discord notification bot.
 package main
 
 import "encoding/json"
 import "net/http"
+import "strconv"
 import "strings"
 import "time"
 import "fmt"
@@ -34,11 +35,24 @@ import "github.com/bwmarrin/discordgo"
 // https://discordapp.com/oauth2/authorize?client_id=472522276101816320&scope=bot&permissions=2048
 var (
 	APIKey           string
-	WarframeEndpoint = "https://api.warframestat.us/pc/"
-	//WarframeEndpoint = "https://api.warframestat.us/pc/invasions"
+	WarframeEndpoint = "https://api.warframestat.us/"
+	PollInterval     = 1 * time.Minute
 )
 
+var Platforms = []string{"pc", "ps4", "xb1", "swi"}
+
+func isPlatform(p string) bool {
+	for _, v := range Platforms {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
+	openDB()
+
 	// Spin up the server.
 
 	// Create a new Discord session using the provided bot token.
@@ -57,6 +71,10 @@ func main() {
 		return
 	}
 
+	backfillGuilds(dg)
+
+	out := NewOutbox(dg, OutboxWorkers)
+
 	for {
 		//fmt.Println("Checking alerts...")
 		channels, err := getChannels()
@@ -79,157 +97,280 @@ func main() {
 			continue
 		}
 
-		// Alerts
-		r, err := http.Get(WarframeEndpoint + "alerts")
+		styles, err := getGuildStyles()
 		if err != nil {
-			if r != nil {
-				r.Body.Close()
-			}
-			fmt.Println("HTTP GET Error:", err)
-			time.Sleep(30 * time.Second)
-			continue
+			fmt.Println("DB Error:", err)
 		}
 
-		dec := json.NewDecoder(r.Body)
-		alerts := []*AlertData{}
-		err = dec.Decode(&alerts)
-		r.Body.Close()
-		if err != nil {
-			fmt.Println("Payload Decode Error:", err)
-			time.Sleep(30 * time.Second)
-			continue
+		// Only fetch the platforms someone is subscribed to.
+		used := map[string]bool{}
+		for _, channel := range channels {
+			used[channel.Platform] = true
 		}
-
-		// Invasions
-		r, err = http.Get(WarframeEndpoint + "invasions")
+		for _, filter := range filters {
+			used[filter.Platform] = true
+		}
+		cycleMessages, err := getCycleMessages()
 		if err != nil {
-			if r != nil {
-				r.Body.Close()
-			}
-			fmt.Println("HTTP GET Error:", err)
-			time.Sleep(30 * time.Second)
-			continue
+			fmt.Println("DB Error:", err)
 		}
-
-		dec = json.NewDecoder(r.Body)
-		invasions := []*InvasionData{}
-		err = dec.Decode(&invasions)
-		r.Body.Close()
+		for _, m := range cycleMessages {
+			used[m.Platform] = true
+		}
+		cycleAlerts, err := getCycleAlerts("")
 		if err != nil {
-			fmt.Println("Payload Decode Error:", err)
-			time.Sleep(30 * time.Second)
-			continue
+			fmt.Println("DB Error:", err)
+		}
+		for _, a := range cycleAlerts {
+			used[a.Platform] = true
 		}
 
-		// Send/update alert/invasion messages.
-		for _, alert := range alerts {
-			message, ok := messages[1][alert.ID]
-			if !ok {
-				sendMessage(dg, channels, filters, alert)
+		failed := map[string]bool{}
+		for _, platform := range Platforms {
+			if !used[platform] {
 				continue
 			}
-			// update messages
-			editMessages(dg, message, alert, false)
-			delete(messages[1], alert.ID)
-		}
-		for _, invasion := range invasions {
-			message, ok := messages[0][invasion.ID]
-			if !ok {
-				sendMessage(dg, channels, filters, invasion)
+
+			ws, err := getWorldState(platform)
+			if err != nil {
+				fmt.Println("Worldstate Error:", platform, err)
+				failed[platform] = true
 				continue
 			}
-			// update messages
-			editMessages(dg, message, invasion, false)
-			delete(messages[0], invasion.ID)
+
+			err = ws.Prepare(platform)
+			if err != nil {
+				fmt.Println("DB Error:", err)
+			}
+
+			checkNightwave(out, platform, ws.Nightwave, filters, styles)
+
+			// Send/update messages.
+			for _, item := range ws.Items() {
+				aid, typ := item.GetID()
+				key := messageKey{platform, aid, typ}
+				message, ok := messages[key]
+				if !ok {
+					sendMessage(out, platform, channels, filters, styles, item)
+					continue
+				}
+				// update messages
+				editMessages(out, message, item, styles, false)
+				planTransitions(out, item, message, styles, time.Now())
+				delete(messages, key)
+			}
 		}
 
-		// Kill orphans
-		for typ := 0; typ < 2; typ++ {
-			alert := typ == 1
-			for aid, messages := range messages[typ] {
-				editMessages(dg, messages, nil, false)
-				removeMessage(aid, alert)
+		// Kill orphans. Leave the ones for platforms we couldn't read this time, they may still be live.
+		for key, messages := range messages {
+			if failed[key.Platform] {
+				continue
+			}
+			if key.Typ == TypeVoidTrader && strings.HasSuffix(key.AID, ":arrived") {
+				expireMessages(out, messages, "departed")
+			} else {
+				editMessages(out, messages, nil, styles, false)
 			}
+			removeMessage(key)
 		}
 
-		time.Sleep(1 * time.Minute)
+		// Cycles are worked out from the last known state, so these are fine even if the fetch failed.
+		now := time.Now()
+		updateCycleMessages(out, styles, now)
+		for platform := range used {
+			checkCycleAlerts(out, platform, now)
+		}
+
+		// Everything has to be sent (and recorded) before the next check, or new items would be sent twice.
+		out.Wait()
+
+		time.Sleep(PollInterval)
 	}
 	//dg.Close()
 }
 
-func sendMessage(s *discordgo.Session, channels []string, filters []userFilter, item Embedable) {
-	msg := item.AsEmbed(false)
-	for _, id := range channels {
-		mdat, err := s.ChannelMessageSendEmbed(id, msg)
-		if err != nil {
-			fmt.Println("Error sending message to:", id, err)
-			continue
+func getWorldState(platform string) (*WorldState, error) {
+	r, err := http.Get(WarframeEndpoint + platform + "/")
+	if err != nil {
+		if r != nil {
+			r.Body.Close()
 		}
-		aid, alert := item.GetID()
-		err = addMessage(id, mdat.ID, aid, alert)
-		if err != nil {
-			fmt.Println("DB Error:", err)
-			continue
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	ws := &WorldState{}
+	err = json.NewDecoder(r.Body).Decode(ws)
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func sendMessage(out *Outbox, platform string, channels []postChannel, filters []userFilter, styles map[string]EmbedStyle, item Embedable) {
+	embeds := map[EmbedStyle]*discordgo.MessageEmbed{}
+	render := func(guild string) *discordgo.MessageEmbed {
+		style := styles[guild]
+		if embeds[style] == nil {
+			embeds[style] = item.AsEmbed(false, style)
 		}
+		return embeds[style]
 	}
-	for _, filter := range filters {
-		if !strings.Contains(strings.ToLower(item.FilterString()), filter.Filter) {
+
+	aid, typ := item.GetID()
+	key := messageKey{platform, aid, typ}
+	match := itemMatch(item)
+	for _, channel := range channels {
+		if channel.Platform != platform || !channel.wants(typ, match) {
 			continue
 		}
 
-		ch, err := s.UserChannelCreate(filter.UID)
-		if err != nil {
-			fmt.Println("Error sending message to:", ch.ID, err)
-			continue
+		channel := channel
+		msg := render(channel.Guild)
+		send := &discordgo.MessageSend{Embed: msg}
+		if roles := channel.mentionRoles(match); len(roles) > 0 {
+			send.Content = "<@&" + strings.Join(roles, "> <@&") + ">"
+			send.AllowedMentions = &discordgo.MessageAllowedMentions{Roles: roles}
 		}
-		mdat, err := s.ChannelMessageSendEmbed(ch.ID, msg)
-		if err != nil {
-			fmt.Println("Error sending message to:", ch.ID, err)
+		out.Add(PrioritySend, channel.ID, discordgo.EndpointChannelMessages(channel.ID), func() {
+			mdat, err := out.s.ChannelMessageSendComplex(channel.ID, send)
+			if err != nil {
+				fmt.Println("Error sending message to:", channel.ID, err)
+				return
+			}
+			embedChanged(mdat.ID, msg)
+			err = addMessage(channel.Guild, channel.ID, mdat.ID, key)
+			if err != nil {
+				fmt.Println("DB Error:", err)
+			}
+		})
+	}
+	// Nightwave filters match single challenges, see checkNightwave.
+	if typ == TypeNightwave {
+		return
+	}
+	for _, filter := range filters {
+		if filter.Platform != platform || !filter.expr.match(match) {
 			continue
 		}
-		aid, alert := item.GetID()
-		err = addMessage(ch.ID, mdat.ID, aid, alert)
-		if err != nil {
-			fmt.Println("DB Error:", err)
+
+		filter := filter
+		msg := render(filter.Guild)
+		out.Add(PrioritySend, "dm:"+filter.UID, discordgo.EndpointUserChannels(""), func() {
+			ch, err := out.s.UserChannelCreate(filter.UID)
+			if err != nil {
+				fmt.Println("Error creating DM channel for:", filter.UID, err)
+				return
+			}
+			mdat, err := out.s.ChannelMessageSendEmbed(ch.ID, msg)
+			if err != nil {
+				fmt.Println("Error sending message to:", ch.ID, err)
+				return
+			}
+			embedChanged(mdat.ID, msg)
+			err = addMessage(filter.Guild, ch.ID, mdat.ID, key)
+			if err != nil {
+				fmt.Println("DB Error:", err)
+			}
+		})
+	}
+}
+
+func (c *postChannel) wants(typ int, match *filterMatch) bool {
+	if len(c.Filters) == 0 {
+		return ChannelTypes[typ]
+	}
+	for _, expr := range c.exprs {
+		if expr.match(match) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *postChannel) mentionRoles(match *filterMatch) []string {
+	roles := []string{}
+	seen := map[string]bool{}
+	for _, m := range c.Mentions {
+		if seen[m.Role] || !m.expr.match(match) {
 			continue
 		}
+		seen[m.Role] = true
+		roles = append(roles, m.Role)
 	}
+	return roles
 }
 
-func editMessages(s *discordgo.Session, messages []event, item Embedable, log bool) {
+func editMessages(out *Outbox, messages []event, item Embedable, styles map[string]EmbedStyle, log bool) {
 	if log {
 		//fmt.Printf("%#v\n", item)
 	}
 
+	if item == nil {
+		expireMessages(out, messages, "")
+		return
+	}
+
+	embeds := map[EmbedStyle]*discordgo.MessageEmbed{}
+	for _, message := range messages {
+		style := styles[message.Guild]
+		if embeds[style] == nil {
+			embeds[style] = item.AsEmbed(log, style)
+		}
+		embed := embeds[style]
+		if !embedChanged(message.MID, embed) {
+			continue
+		}
+
+		message := message
+		out.Add(PriorityEdit, message.CID, discordgo.EndpointChannelMessage(message.CID, ""), func() {
+			_, err := out.s.ChannelMessageEditEmbed(message.CID, message.MID, embed)
+			if err != nil {
+				fmt.Println("Error editing message to:", message.MID, err)
+				forgetEmbed(message.MID)
+			}
+		})
+	}
+}
+
+// Turns the messages red and drops the fields. If note is set it is added to the title.
+func expireMessages(out *Outbox, messages []event, note string) {
 	for _, message := range messages {
-		if item == nil {
-			m, err := s.ChannelMessage(message.CID, message.MID)
+		forgetEmbed(message.MID)
+
+		message := message
+		out.Add(PriorityExpire, message.CID, discordgo.EndpointChannelMessage(message.CID, ""), func() {
+			m, err := out.s.ChannelMessage(message.CID, message.MID)
 			if err != nil {
 				fmt.Println("Error reading old message:", err)
-				continue
+				return
 			}
 
 			if len(m.Embeds) == 0 {
 				fmt.Println("Message with no embed:", message.MID, err)
-				continue
+				return
 			}
 
 			embed := m.Embeds[0]
 			embed.Color = 0xff0000
-			embed.Fields = nil
 
-			_, err = s.ChannelMessageEditEmbed(message.CID, message.MID, embed)
+			// Keep whatever the post was about (missions, challenges and so on), only the times are stale.
+			fields := []*discordgo.MessageEmbedField{}
+			for _, f := range embed.Fields {
+				if !timeFieldNames[f.Name] {
+					fields = append(fields, f)
+				}
+			}
+			embed.Fields = fields
+			if note != "" {
+				embed.Title = strings.TrimSuffix(embed.Title, ":") + ": " + note
+			}
+
+			_, err = out.s.ChannelMessageEditEmbed(message.CID, message.MID, embed)
 			if err != nil {
 				fmt.Println("Error editing message to:", message.MID, err)
 			}
-			continue
-		}
-
-		_, err := s.ChannelMessageEditEmbed(message.CID, message.MID, item.AsEmbed(log))
-		if err != nil {
-			fmt.Println("Error editing message to:", message.MID, err)
-			continue
-		}
+		})
 	}
 }
 
@@ -238,33 +379,57 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	perm, err := s.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	gs, err := getGuildSettings(m.GuildID)
 	if err != nil {
+		fmt.Println("DB Error:", err)
 		return
 	}
-	isAdmin := perm&discordgo.PermissionAdministrator != 0 || perm&discordgo.PermissionManageServer != 0 || perm&discordgo.PermissionManageChannels != 0
 
+	// Commands are matched with the guild's prefix, then it is swapped for the default so the cases below
+	// don't need to care which one the guild uses.
 	command := parseCommand(m.Content)
-	if len(command) < 1 {
+	if len(command) < 1 || !strings.HasPrefix(command[0], gs.Prefix) {
+		return
+	}
+	command[0] = "&" + strings.TrimPrefix(command[0], gs.Prefix)
+
+	perm, err := s.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err != nil {
 		return
 	}
+	isAdmin := perm&discordgo.PermissionAdministrator != 0 || perm&discordgo.PermissionManageServer != 0 || perm&discordgo.PermissionManageChannels != 0
+	isAdmin = isAdmin || hasRole(m.Member, gs.AdminRole)
 
 	switch command[0] {
 	case "&help":
-		s.ChannelMessageSend(m.ChannelID, "Try: `&filter \"filter text\"`, `&rmfilter \"filter text\"`, `&filters`, `&post` (admin only), or `&nopost` (admin only)")
+		s.ChannelMessageSend(m.ChannelID, strings.ReplaceAll("Try: `&filter \"filter text\" [platform]` (like `&filter \"axi survival\"`, `&filter \"reward:nitain and not kind:invasion\"` or `&filter \"node:/^(ceres|mars)/ and faction:'corrupted'\"`; fields are reward, node, faction, type, kind, and attacker and defender for one side of an invasion (like `defender:fieldron`), with and, or, not, parentheses, 'phrases' and /regex/), `&rmfilter \"filter text\" [platform]`, `&filters`, `&cyclealert cycle state [minutes] [platform]`, `&rmcyclealert cycle state`, `&cyclealerts`, `&post [platform]` (admin only), `&nopost` (admin only), `&chanfilter \"filter text\"` (admin only, same syntax as `&filter`), `&rmchanfilter \"filter text\"` (admin only), `&mention @role \"filter text\"` (admin only), `&rmmention @role [\"filter text\"]` (admin only), `&chanfilters`, `&cycles [platform]` (admin only), `&nocycles` (admin only), `&settings`, `&set prefix|platform|language|adminrole|style value` (admin only), or `&config` (admin only)\nPlatforms: "+strings.Join(Platforms, ", ")+" (default "+gs.Platform+")", "`&", "`"+gs.Prefix))
+	case "&settings", "&set":
+		settingsCommand(s, m, command, gs, isAdmin)
+	case "&config":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		configCommand(s, m, gs)
 	case "&post":
 		if !isAdmin {
 			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
 			return
 		}
 
-		err := addChannel(m.ChannelID)
+		platform, ok := platformArg(command, 1, gs.Platform)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown platform, try one of: "+strings.Join(Platforms, ", "))
+			return
+		}
+
+		err := addChannel(m.ChannelID, m.GuildID, platform)
 		if err != nil {
 			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
 			fmt.Println("Channel add error:", err)
 			return
 		}
-		s.ChannelMessageSend(m.ChannelID, "Now posting alerts here.")
+		s.ChannelMessageSend(m.ChannelID, "Now posting "+platform+" alerts here.")
 	case "&nopost":
 		if !isAdmin {
 			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
@@ -278,24 +443,249 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 			return
 		}
 		s.ChannelMessageSend(m.ChannelID, "No longer posting alerts here.")
+	case "&chanfilter":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		if len(command) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+		_, err := parseFilter(command[1])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Bad filter: "+err.Error())
+			return
+		}
+
+		err = addChannelFilter(m.ChannelID, command[1])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Channel filter add error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "This channel now gets items matching: "+command[1])
+	case "&rmchanfilter":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		if len(command) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+
+		err := removeChannelFilter(m.ChannelID, command[1])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Channel filter remove error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Removed channel filter: "+command[1])
+	case "&mention":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `"+gs.Prefix+"mention @role \"filter text\"`")
+			return
+		}
+		role, ok := roleArg(command[1])
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Not a role: "+command[1])
+			return
+		}
+		_, err := parseFilter(command[2])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Bad filter: "+err.Error())
+			return
+		}
+
+		err = addChannelMention(m.ChannelID, role, command[2])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Channel mention add error:", err)
+			return
+		}
+		s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content:         "Will mention <@&" + role + "> for items matching: " + command[2],
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+	case "&rmmention":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+		if len(command) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+		role, ok := roleArg(command[1])
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Not a role: "+command[1])
+			return
+		}
+		filter := ""
+		if len(command) > 2 {
+			filter = command[2]
+		}
+
+		err := removeChannelMention(m.ChannelID, role, filter)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Channel mention remove error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Removed mention rule.")
+	case "&chanfilters":
+		filters, err := getChannelFilters()
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Channel filter list error:", err)
+			return
+		}
+		mentions, err := getChannelMentions()
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Channel mention list error:", err)
+			return
+		}
+
+		msg := "Channel Filters:"
+		if len(filters[m.ChannelID]) == 0 {
+			msg += "\nNone, every alert and invasion is posted."
+		}
+		for _, filter := range filters[m.ChannelID] {
+			msg += "\n" + filter
+		}
+		msg += "\nMentions:"
+		for _, mention := range mentions[m.ChannelID] {
+			msg += "\n<@&" + mention.Role + "> for " + mention.Filter
+		}
+		s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content:         msg,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+	case "&cycles":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+
+		platform, ok := platformArg(command, 1, gs.Platform)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown platform, try one of: "+strings.Join(Platforms, ", "))
+			return
+		}
+		postCycleMessage(s, m.GuildID, m.ChannelID, platform, cyclesEmbed(platform, parseStyle(gs.Style), time.Now()))
+	case "&nocycles":
+		if !isAdmin {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, you are not the server admin.")
+			return
+		}
+
+		err := removeCycleMessage(m.ChannelID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Cycle message remove error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "No longer updating the cycle status here.")
+	case "&cyclealert":
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `"+gs.Prefix+"cyclealert cycle state [minutes] [platform]`, cycles are: "+cycleNames())
+			return
+		}
+		cycle, state := strings.ToLower(command[1]), strings.ToLower(command[2])
+		def := getCycleDef(cycle)
+		if def == nil || def.stateIndex(state) < 0 {
+			s.ChannelMessageSend(m.ChannelID, "Unknown cycle or state, try one of: "+cycleNames())
+			return
+		}
+		lead := 5
+		if len(command) > 3 {
+			lead, err = strconv.Atoi(command[3])
+			if err != nil || lead < 1 {
+				s.ChannelMessageSend(m.ChannelID, "Invalid number of minutes (needs to be at least 1): "+command[3])
+				return
+			}
+		}
+		platform, ok := platformArg(command, 4, gs.Platform)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown platform, try one of: "+strings.Join(Platforms, ", "))
+			return
+		}
+
+		err := addCycleAlert(cycleAlert{UID: m.Author.ID, Guild: m.GuildID, Platform: platform, Cycle: cycle, State: state, Lead: lead})
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Cycle alert add error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("You will get a DM %d minutes before %v %v.", lead, def.Title, state))
+	case "&rmcyclealert":
+		if len(command) < 3 {
+			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
+			return
+		}
+		err := removeCycleAlert(m.Author.ID, m.GuildID, strings.ToLower(command[1]), strings.ToLower(command[2]))
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Cycle alert remove error:", err)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Removed cycle alert: "+command[1]+" "+command[2])
+	case "&cyclealerts":
+		alerts, err := getCycleAlerts(m.Author.ID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
+			fmt.Println("Cycle alert list error:", err)
+			return
+		}
+		msg := "Your Cycle Alerts:"
+		for _, a := range alerts {
+			if a.Guild != m.GuildID && a.Guild != "" {
+				continue
+			}
+			msg += fmt.Sprintf("\n%v %v, %d minutes before (%v)", a.Cycle, a.State, a.Lead, a.Platform)
+		}
+		s.ChannelMessageSend(m.ChannelID, msg)
 	case "&filter":
 		if len(command) < 2 {
 			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
 			return
 		}
-		err := addFilter(m.Author.ID, command[1])
+		platform, ok := platformArg(command, 2, gs.Platform)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "Unknown platform, try one of: "+strings.Join(Platforms, ", "))
+			return
+		}
+
+		_, err := parseFilter(command[1])
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Bad filter: "+err.Error())
+			return
+		}
+
+		err = addFilter(m.Author.ID, m.GuildID, command[1], platform)
 		if err != nil {
 			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
 			fmt.Println("Filter add error:", err)
 			return
 		}
-		s.ChannelMessageSend(m.ChannelID, "Added filter: "+command[1])
+		s.ChannelMessageSend(m.ChannelID, "Added "+platform+" filter: "+command[1])
 	case "&rmfilter":
 		if len(command) < 2 {
 			s.ChannelMessageSend(m.ChannelID, "Argument needed.")
 			return
 		}
-		err := removeFilter(m.Author.ID, command[1])
+		platform := ""
+		if len(command) > 2 {
+			platform = strings.ToLower(command[2])
+		}
+
+		err := removeFilter(m.Author.ID, m.GuildID, command[1], platform)
 		if err != nil {
 			s.ChannelMessageSend(m.ChannelID, "Error, check server logs.")
 			fmt.Println("Filter remove error:", err)
@@ -311,15 +701,41 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		}
 		msg := "Your Filters:"
 		for _, filter := range filters {
-			msg += fmt.Sprint("\n", filter.Filter)
+			if filter.Guild != m.GuildID && filter.Guild != "" {
+				continue
+			}
+			msg += fmt.Sprint("\n", filter.Filter, " (", filter.Platform, ")")
 		}
 		s.ChannelMessageSend(m.ChannelID, msg)
 	}
 }
 
+// Takes a role mention or a bare role ID.
+func roleArg(arg string) (string, bool) {
+	id := strings.TrimSuffix(strings.TrimPrefix(arg, "<@&"), ">")
+	if id == "" {
+		return "", false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return id, true
+}
+
 func onConnect(s *discordgo.Session, r *discordgo.Ready) {
 	// Discard the error, it doesn't hurt anything if this fails.
-	_ = s.UpdateStatus(-1, "Warframe | &help")
+	_ = s.UpdateGameStatus(0, "Warframe | &help")
+}
+
+// Returns the platform given at command[i], or def if there isn't one.
+func platformArg(command []string, i int, def string) (string, bool) {
+	if len(command) <= i {
+		return def, true
+	}
+	platform := strings.ToLower(command[i])
+	return platform, isPlatform(platform)
 }
 
 // For when strings.Split just isn't good enough...
@@ -0,0 +1,201 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+type NightwaveData struct {
+	ID         string                   `json:"id"`
+	Activation time.Time                `json:"activation"`
+	Expiry     time.Time                `json:"expiry"`
+	Season     int                      `json:"season"`
+	Tag        string                   `json:"tag"`
+	Challenges []NightwaveChallengeData `json:"activeChallenges"`
+}
+
+type NightwaveChallengeData struct {
+	ID         string    `json:"id"`
+	Activation time.Time `json:"activation"`
+	Expiry     time.Time `json:"expiry"`
+	Daily      bool      `json:"isDaily"`
+	Elite      bool      `json:"isElite"`
+	Title      string    `json:"title"`
+	Desc       string    `json:"desc"`
+	Reputation int       `json:"reputation"`
+}
+
+func (c *NightwaveChallengeData) String(style EmbedStyle) string {
+	remaining := "*expired*"
+	if d := c.Expiry.Sub(time.Now()); d.Round(time.Minute) >= time.Minute {
+		remaining = style.at(c.Expiry, formatDuration(d)+" left")
+	}
+	return fmt.Sprintf("**%v**: %v (%d standing, %v)", c.Title, c.Desc, c.Reputation, remaining)
+}
+
+// The weekly challenges all start at reset, so the board is identified by when that was.
+func (a *NightwaveData) week() time.Time {
+	week := time.Time{}
+	for _, c := range a.Challenges {
+		if !c.Daily && c.Activation.After(week) {
+			week = c.Activation
+		}
+	}
+	return week
+}
+
+func (a *NightwaveData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	sections := []struct {
+		Name  string
+		Match func(c *NightwaveChallengeData) bool
+	}{
+		{"Daily:", func(c *NightwaveChallengeData) bool { return c.Daily }},
+		{"Weekly:", func(c *NightwaveChallengeData) bool { return !c.Daily && !c.Elite }},
+		{"Elite Weekly:", func(c *NightwaveChallengeData) bool { return !c.Daily && c.Elite }},
+	}
+
+	fields := []*discordgo.MessageEmbedField{}
+	for _, section := range sections {
+		lines := ""
+		for i := range a.Challenges {
+			c := &a.Challenges[i]
+			if !section.Match(c) {
+				continue
+			}
+			line := c.String(style) + "\n"
+
+			// Field values max out at 1024.
+			if len(lines)+len(line) > 1000 {
+				lines += "..."
+				break
+			}
+			lines += line
+		}
+		if lines == "" {
+			continue
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: section.Name, Value: lines})
+	}
+
+	color := 0x00ff00
+	desc := fmt.Sprintf("Season %d", a.Season)
+	if a.Tag != "" {
+		desc = a.Tag + ", " + strings.ToLower(desc)
+	}
+	if a.Expiry.Sub(time.Now()).Round(time.Minute) < time.Minute {
+		color = 0xff0000
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:       color,
+		Title:       "Nightwave:",
+		Description: desc,
+		Fields:      fields,
+	}
+}
+
+func (a *NightwaveData) Transitions() []time.Time {
+	times := []time.Time{a.Expiry}
+	for _, c := range a.Challenges {
+		times = append(times, c.Expiry)
+	}
+	return times
+}
+
+// Filters match single challenges (see checkNightwave), never the whole board.
+func (a *NightwaveData) FilterString() string {
+	return ""
+}
+
+func (a *NightwaveData) FilterFields() FilterFields {
+	return FilterFields{}
+}
+
+func (a *NightwaveData) GetID() (string, int) {
+	return fmt.Sprintf("%v:%d", a.ID, a.week().Unix()), TypeNightwave
+}
+
+// DMs anyone with a filter matching a challenge we haven't seen before.
+func checkNightwave(out *Outbox, platform string, nw *NightwaveData, filters []userFilter, styles map[string]EmbedStyle) {
+	if nw == nil {
+		return
+	}
+
+	seen, err := getSeenChallenges(platform)
+	if err != nil {
+		fmt.Println("DB Error:", err)
+		return
+	}
+	// First run, don't DM for everything that is already up.
+	quiet := len(seen) == 0
+
+	for i := range nw.Challenges {
+		c := &nw.Challenges[i]
+		if seen[c.ID] {
+			continue
+		}
+		err := addSeenChallenge(platform, c.ID)
+		if err != nil {
+			fmt.Println("DB Error:", err)
+			continue
+		}
+		if quiet {
+			continue
+		}
+
+		kind := "Weekly"
+		switch {
+		case c.Daily:
+			kind = "Daily"
+		case c.Elite:
+			kind = "Elite Weekly"
+		}
+		match := &filterMatch{Text: c.Title + " " + c.Desc, Kind: "nightwave", Fields: FilterFields{Type: c.Title}}
+		for _, filter := range filters {
+			if filter.Platform != platform || !filter.expr.match(match) {
+				continue
+			}
+
+			filter := filter
+			msg := &discordgo.MessageEmbed{
+				Color:       0x00ff00,
+				Title:       "Nightwave " + kind + " Challenge:",
+				Description: c.String(styles[filter.Guild]),
+			}
+			out.Add(PrioritySend, "dm:"+filter.UID, discordgo.EndpointUserChannels(""), func() {
+				ch, err := out.s.UserChannelCreate(filter.UID)
+				if err != nil {
+					fmt.Println("Error creating DM channel for:", filter.UID, err)
+					return
+				}
+				_, err = out.s.ChannelMessageSendEmbed(ch.ID, msg)
+				if err != nil {
+					fmt.Println("Error sending message to:", ch.ID, err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "encoding/json"
+import "sync"
+import "time"
+
+import "github.com/bwmarrin/discordgo"
+
+// Job priorities, lower goes first. New posts are what people are waiting on, countdown edits can wait,
+// and turning old messages red can wait longest.
+const (
+	PrioritySend = iota
+	PriorityEdit
+	PriorityExpire
+)
+
+// How many requests may be in flight at once. Jobs for the same channel never run at the same time.
+var OutboxWorkers = 4
+
+type job struct {
+	Priority int
+	Channel  string // Jobs with the same channel run one at a time.
+	Bucket   string // discordgo rate limit bucket the job's request goes to.
+	Run      func()
+
+	seq uint64
+}
+
+// Outbox runs queued Discord requests on a pool of workers, most important first, and holds back jobs
+// whose rate limit bucket is empty so they don't tie up a worker.
+type Outbox struct {
+	s *discordgo.Session
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pending []*job
+	busy    map[string]bool
+	running int
+	seq     uint64
+	wakeAt  time.Time
+}
+
+func NewOutbox(s *discordgo.Session, workers int) *Outbox {
+	o := &Outbox{s: s, busy: map[string]bool{}}
+	o.cond = sync.NewCond(&o.lock)
+	for i := 0; i < workers; i++ {
+		go o.worker()
+	}
+	return o
+}
+
+func (o *Outbox) Add(priority int, channel, bucket string, run func()) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.seq++
+	o.pending = append(o.pending, &job{Priority: priority, Channel: channel, Bucket: bucket, Run: run, seq: o.seq})
+	o.cond.Broadcast()
+}
+
+// Wait blocks until everything queued so far has run.
+func (o *Outbox) Wait() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for len(o.pending) > 0 || o.running > 0 {
+		o.cond.Wait()
+	}
+}
+
+func (o *Outbox) worker() {
+	o.lock.Lock()
+	for {
+		j := o.next()
+		if j == nil {
+			o.cond.Wait()
+			continue
+		}
+		o.busy[j.Channel] = true
+		o.running++
+		o.lock.Unlock()
+
+		j.Run()
+
+		o.lock.Lock()
+		delete(o.busy, j.Channel)
+		o.running--
+		o.cond.Broadcast()
+	}
+}
+
+// Takes the most important job that can run right now off the queue. Must be called with the lock held.
+func (o *Outbox) next() *job {
+	best := -1
+	wait := time.Duration(0)
+	for i, j := range o.pending {
+		if o.busy[j.Channel] {
+			continue
+		}
+		if best >= 0 && (j.Priority > o.pending[best].Priority || j.Priority == o.pending[best].Priority && j.seq > o.pending[best].seq) {
+			continue
+		}
+		if w := o.s.Ratelimiter.GetWaitTime(o.s.Ratelimiter.GetBucket(j.Bucket), 1); w > 0 {
+			if wait == 0 || w < wait {
+				wait = w
+			}
+			continue
+		}
+		best = i
+	}
+
+	if best < 0 {
+		// Everything left is rate limited, check again once the first bucket resets.
+		if wait > 0 && (o.wakeAt.IsZero() || time.Now().Add(wait).Before(o.wakeAt)) {
+			o.wakeAt = time.Now().Add(wait)
+			time.AfterFunc(wait, func() {
+				o.lock.Lock()
+				o.wakeAt = time.Time{}
+				o.cond.Broadcast()
+				o.lock.Unlock()
+			})
+		}
+		return nil
+	}
+
+	j := o.pending[best]
+	o.pending = append(o.pending[:best], o.pending[best+1:]...)
+	return j
+}
+
+// The last embed each message was set to, so edits that wouldn't change anything can be skipped.
+var rendered = map[string]string{}
+var renderedLock sync.Mutex
+
+// Reports if the message needs editing to show the embed, and assumes it will be.
+func embedChanged(mid string, embed *discordgo.MessageEmbed) bool {
+	b, err := json.Marshal(embed)
+	if err != nil {
+		return true
+	}
+
+	renderedLock.Lock()
+	defer renderedLock.Unlock()
+	if rendered[mid] == string(b) {
+		return false
+	}
+	rendered[mid] = string(b)
+	return true
+}
+
+// Call if an edit failed or the message is done with.
+func forgetEmbed(mid string) {
+	renderedLock.Lock()
+	defer renderedLock.Unlock()
+	delete(rendered, mid)
+}
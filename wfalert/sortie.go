@@ -0,0 +1,168 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+type SortieData struct {
+	ID         string              `json:"id"`
+	Activation time.Time           `json:"activation"`
+	Expiry     time.Time           `json:"expiry"`
+	Boss       string              `json:"boss"`
+	Faction    string              `json:"faction"`
+	Variants   []SortieMissionData `json:"variants"`
+	Expired    bool                `json:"expired"`
+}
+
+type SortieMissionData struct {
+	MissionType string `json:"missionType"`
+	Modifier    string `json:"modifier"`
+	Node        string `json:"node"`
+}
+
+func (a *SortieData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{}
+	for i, v := range a.Variants {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%d. %v at %v", i+1, v.MissionType, v.Node),
+			Value: orDefault(v.Modifier, "No modifier"),
+		})
+	}
+
+	return resetEmbed("Sortie:", a.Boss+" ("+a.Faction+")", fields, a.Activation, a.Expiry, a.Expired, style)
+}
+
+func (a *SortieData) Transitions() []time.Time {
+	return []time.Time{a.Activation, a.Expiry}
+}
+
+func (a *SortieData) FilterString() string {
+	missions := []string{}
+	for _, v := range a.Variants {
+		missions = append(missions, v.MissionType+" at "+v.Node+" ("+v.Modifier+")")
+	}
+	return "Sortie: " + a.Boss + " " + a.Faction + ": " + strings.Join(missions, ", ")
+}
+
+func (a *SortieData) FilterFields() FilterFields {
+	nodes, types := []string{}, []string{}
+	for _, v := range a.Variants {
+		nodes = append(nodes, v.Node)
+		types = append(types, v.MissionType)
+	}
+	return FilterFields{Node: strings.Join(nodes, ", "), Faction: a.Faction, Type: strings.Join(types, ", ")}
+}
+
+func (a *SortieData) GetID() (string, int) {
+	return a.ID, TypeSortie
+}
+
+type ArchonHuntData struct {
+	ID         string                  `json:"id"`
+	Activation time.Time               `json:"activation"`
+	Expiry     time.Time               `json:"expiry"`
+	Boss       string                  `json:"boss"`
+	Faction    string                  `json:"faction"`
+	Missions   []ArchonHuntMissionData `json:"missions"`
+	Expired    bool                    `json:"expired"`
+}
+
+type ArchonHuntMissionData struct {
+	Node string `json:"node"`
+	Type string `json:"type"`
+}
+
+func (a *ArchonHuntData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{}
+	for i, v := range a.Missions {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%d. %v", i+1, v.Type),
+			Value: v.Node,
+		})
+	}
+
+	return resetEmbed("Archon Hunt:", a.Boss+" ("+a.Faction+")", fields, a.Activation, a.Expiry, a.Expired, style)
+}
+
+func (a *ArchonHuntData) Transitions() []time.Time {
+	return []time.Time{a.Activation, a.Expiry}
+}
+
+func (a *ArchonHuntData) FilterString() string {
+	missions := []string{}
+	for _, v := range a.Missions {
+		missions = append(missions, v.Type+" at "+v.Node)
+	}
+	return "Archon Hunt: " + a.Boss + " " + a.Faction + ": " + strings.Join(missions, ", ")
+}
+
+func (a *ArchonHuntData) FilterFields() FilterFields {
+	nodes, types := []string{}, []string{}
+	for _, v := range a.Missions {
+		nodes = append(nodes, v.Node)
+		types = append(types, v.Type)
+	}
+	return FilterFields{Node: strings.Join(nodes, ", "), Faction: a.Faction, Type: strings.Join(types, ", ")}
+}
+
+func (a *ArchonHuntData) GetID() (string, int) {
+	return a.ID, TypeArchonHunt
+}
+
+// Shared by the things that reset on a schedule, the mission fields go after the countdown.
+func resetEmbed(title, desc string, missions []*discordgo.MessageEmbedField, activation, expiry time.Time, expired bool, style EmbedStyle) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{}
+
+	color := 0x00ff00 // Assume green (AKA, "currently running").
+
+	// If it hasn't started yet, add a field with the time till start.
+	if activation.After(time.Now()) {
+		color = 0x0000ff
+		fields = append(fields, style.timeField("Starting in:", "Starts:", activation, formatDuration(activation.Sub(time.Now()))))
+	}
+
+	// If it has expired change the color to red, otherwise add a field with the time until it ends.
+	if expired || expiry.Sub(time.Now()).Round(time.Minute) < time.Minute {
+		color = 0xff0000
+	} else {
+		fields = append(fields, style.timeField("Remaining Time:", "Ends:", expiry, formatDuration(expiry.Sub(time.Now()))))
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:       color, // Blue when not started, Green while running, Red when finished.
+		Title:       title,
+		Description: desc,
+		Fields:      append(fields, missions...),
+	}
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
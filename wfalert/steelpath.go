@@ -0,0 +1,134 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+type SteelPathData struct {
+	Activation time.Time           `json:"activation"`
+	Expiry     time.Time           `json:"expiry"`
+	Reward     SteelPathRewardData `json:"currentReward"`
+}
+
+type SteelPathRewardData struct {
+	Name string `json:"name"`
+	Cost int    `json:"cost"`
+}
+
+func (a *SteelPathData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	desc := fmt.Sprintf("Teshin is offering %v for %d Steel Essence", orDefault(a.Reward.Name, "unknown"), a.Reward.Cost)
+	return resetEmbed("Steel Path Honors:", desc, nil, a.Activation, a.Expiry, false, style)
+}
+
+func (a *SteelPathData) Transitions() []time.Time {
+	return []time.Time{a.Activation, a.Expiry}
+}
+
+func (a *SteelPathData) FilterString() string {
+	return "Steel Path: " + a.Reward.Name
+}
+
+func (a *SteelPathData) FilterFields() FilterFields {
+	return FilterFields{Reward: a.Reward.Name}
+}
+
+func (a *SteelPathData) GetID() (string, int) {
+	return fmt.Sprintf("steelpath:%d", a.Activation.Unix()), TypeSteelPath
+}
+
+// The worldstate only has an expiry for the current mood, the circuit itself resets with the week.
+type DuviriData struct {
+	ID      string              `json:"id"`
+	Expiry  time.Time           `json:"expiry"`
+	State   string              `json:"state"`
+	Choices []DuviriChoicesData `json:"choices"`
+}
+
+type DuviriChoicesData struct {
+	Category string   `json:"category"` // "normal" or "hard"
+	Choices  []string `json:"choices"`
+}
+
+func (a *DuviriData) circuit(category string) []string {
+	for _, c := range a.Choices {
+		if c.Category == category {
+			return c.Choices
+		}
+	}
+	return nil
+}
+
+func (a *DuviriData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{}
+	for _, c := range []struct{ Name, Category string }{{"Circuit Warframes:", "normal"}, {"Steel Path Incarnon Adapters:", "hard"}} {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  c.Name,
+			Value: orDefault(strings.Join(a.circuit(c.Category), ", "), "unknown"),
+		})
+	}
+
+	desc := "Mood: " + strings.Title(orDefault(a.State, "unknown"))
+	if a.Expiry.After(time.Now()) {
+		desc += ", changes " + style.at(a.Expiry, "in "+formatDuration(a.Expiry.Sub(time.Now())))
+	}
+
+	return resetEmbed("Duviri Circuit:", desc, fields, time.Time{}, weeklyReset(time.Now()), false, style)
+}
+
+// The mood changing is a transition too.
+func (a *DuviriData) Transitions() []time.Time {
+	return []time.Time{a.Expiry, weeklyReset(time.Now())}
+}
+
+// Weekly reset is Monday at 00:00 UTC.
+func weeklyReset(now time.Time) time.Time {
+	now = now.UTC()
+	reset := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	reset = reset.AddDate(0, 0, (8-int(reset.Weekday()))%7)
+	if !reset.After(now) {
+		reset = reset.AddDate(0, 0, 7)
+	}
+	return reset
+}
+
+func (a *DuviriData) FilterString() string {
+	return "Duviri Circuit: " + strings.Join(a.circuit("normal"), ", ") + " steel path: " + strings.Join(a.circuit("hard"), ", ")
+}
+
+// The rewards are the warframes and Incarnon adapters on offer.
+func (a *DuviriData) FilterFields() FilterFields {
+	return FilterFields{Reward: strings.Join(append(a.circuit("normal"), a.circuit("hard")...), ", ")}
+}
+
+// The circuit has no ID of its own, so key it by the choices. New choices means a new week.
+func (a *DuviriData) GetID() (string, int) {
+	ids := []string{}
+	for _, c := range a.Choices {
+		ids = append(ids, c.Category+"="+strings.Join(c.Choices, ","))
+	}
+	return "duviri:" + strings.Join(ids, ";"), TypeDuviri
+}
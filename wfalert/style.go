@@ -0,0 +1,103 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "fmt"
+
+import "github.com/bwmarrin/discordgo"
+
+// EmbedStyle is how embeds show times, picked per guild.
+type EmbedStyle int
+
+const (
+	// Discord <t:unix:R> timestamps, these count down on their own so messages only need an edit when
+	// something actually changes.
+	StyleRelative EmbedStyle = iota
+
+	// Plain countdowns, these need editing every poll to stay right.
+	StyleCountdown
+)
+
+var StyleNames = map[string]EmbedStyle{
+	"relative":  StyleRelative,
+	"countdown": StyleCountdown,
+}
+
+func parseStyle(name string) EmbedStyle {
+	return StyleNames[name] // Unknown names get the zero value, StyleRelative.
+}
+
+// Shows t as a timestamp, or old for the countdown style.
+func (st EmbedStyle) at(t time.Time, old string) string {
+	if st == StyleCountdown {
+		return old
+	}
+	return fmt.Sprintf("<t:%d:R>", t.Unix())
+}
+
+// Every name and label passed to timeField, so an expired embed can drop the times and keep the rest.
+var timeFieldNames = map[string]bool{
+	"Starting in:":      true,
+	"Starts:":           true,
+	"Remaining Time:":   true,
+	"Ends:":             true,
+	"Arriving in:":      true,
+	"Arrives:":          true,
+	"Projected end in:": true,
+	"Projected end:":    true,
+}
+
+// A field for when something starts or ends. name goes with the countdown style ("Starting in:") and label
+// with a timestamp ("Starts:").
+func (st EmbedStyle) timeField(name, label string, t time.Time, old string) *discordgo.MessageEmbedField {
+	if st == StyleCountdown {
+		return &discordgo.MessageEmbedField{Name: name, Value: old, Inline: true}
+	}
+	return &discordgo.MessageEmbedField{Name: label, Value: st.at(t, old), Inline: true}
+}
+
+// Timed items list the times their embed changes (starting, ending and the like), so those edits can be
+// made on time rather than whenever the next poll happens to notice.
+type Timed interface {
+	Transitions() []time.Time
+}
+
+// Schedules edits for any of the item's transitions before the next poll. The poll handles the rest.
+func planTransitions(out *Outbox, item Embedable, messages []event, styles map[string]EmbedStyle, now time.Time) {
+	timed, ok := item.(Timed)
+	if !ok {
+		return
+	}
+
+	for _, t := range timed.Transitions() {
+		if !t.After(now) || t.After(now.Add(PollInterval)) {
+			continue
+		}
+
+		// A little late, so the item reads as past the transition when it renders.
+		time.AfterFunc(t.Sub(now)+time.Second, func() {
+			editMessages(out, messages, item, styles, false)
+		})
+	}
+}
@@ -28,9 +28,107 @@ import "time"
 import "github.com/bwmarrin/discordgo"
 
 type Embedable interface {
-	AsEmbed(log bool) *discordgo.MessageEmbed
+	AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed
 	FilterString() string
-	GetID() (string, bool)
+	FilterFields() FilterFields
+	GetID() (string, int)
+}
+
+// Item types, as stored in the Messages table. Don't renumber these.
+const (
+	TypeInvasion    = 0
+	TypeAlert       = 1
+	TypeFissure     = 2
+	TypeVoidTrader  = 3
+	TypeSortie      = 4
+	TypeArchonHunt  = 5
+	TypeNightwave   = 6
+	TypeArbitration = 7
+	TypeSteelPath   = 8
+	TypeDuviri      = 9
+)
+
+// Item types sent to every posting channel. Fissures and arbitrations are far too common to post
+// unfiltered, so they only go to matching filters.
+var ChannelTypes = map[int]bool{
+	TypeInvasion:   true,
+	TypeAlert:      true,
+	TypeVoidTrader: true,
+	TypeSortie:     true,
+	TypeArchonHunt: true,
+	TypeNightwave:  true,
+	TypeSteelPath:  true,
+	TypeDuviri:     true,
+}
+
+// WorldState is the parts of a platform's worldstate we use.
+type WorldState struct {
+	Alerts     []*AlertData    `json:"alerts"`
+	Invasions  []*InvasionData `json:"invasions"`
+	Fissures   []*FissureData  `json:"fissures"`
+	VoidTrader *VoidTraderData `json:"voidTrader"`
+	Sortie     *SortieData     `json:"sortie"`
+	ArchonHunt *ArchonHuntData `json:"archonHunt"`
+	Nightwave  *NightwaveData  `json:"nightwave"`
+
+	Arbitration *ArbitrationData `json:"arbitration"`
+	SteelPath   *SteelPathData   `json:"steelPath"`
+	DuviriCycle *DuviriData      `json:"duviriCycle"`
+
+	CetusCycle   *CycleData `json:"cetusCycle"`
+	VallisCycle  *CycleData `json:"vallisCycle"`
+	CambionCycle *CycleData `json:"cambionCycle"`
+	ZarimanCycle *CycleData `json:"zarimanCycle"`
+}
+
+// Loads or stores anything the items need beyond what is in the worldstate itself.
+func (ws *WorldState) Prepare(platform string) error {
+	ws.storeCycles(platform)
+	ws.trackInvasions(platform, time.Now())
+
+	if ws.VoidTrader != nil {
+		err := ws.VoidTrader.prepare(platform)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Everything that should have a message, in the order they should be posted.
+func (ws *WorldState) Items() []Embedable {
+	items := []Embedable{}
+	for _, alert := range ws.Alerts {
+		items = append(items, alert)
+	}
+	for _, invasion := range ws.Invasions {
+		items = append(items, invasion)
+	}
+	for _, fissure := range ws.Fissures {
+		items = append(items, fissure)
+	}
+	if ws.VoidTrader != nil && ws.VoidTrader.ID != "" {
+		items = append(items, ws.VoidTrader)
+	}
+	if ws.Sortie != nil && ws.Sortie.ID != "" {
+		items = append(items, ws.Sortie)
+	}
+	if ws.ArchonHunt != nil && ws.ArchonHunt.ID != "" {
+		items = append(items, ws.ArchonHunt)
+	}
+	if ws.Nightwave != nil && len(ws.Nightwave.Challenges) > 0 {
+		items = append(items, ws.Nightwave)
+	}
+	if ws.Arbitration != nil && ws.Arbitration.known() {
+		items = append(items, ws.Arbitration)
+	}
+	if ws.SteelPath != nil && ws.SteelPath.Reward.Name != "" {
+		items = append(items, ws.SteelPath)
+	}
+	if ws.DuviriCycle != nil && len(ws.DuviriCycle.Choices) > 0 {
+		items = append(items, ws.DuviriCycle)
+	}
+	return items
 }
 
 type AlertData struct {
@@ -58,7 +156,7 @@ func (a *AlertData) String() string {
 	return msg
 }
 
-func (a *AlertData) AsEmbed(log bool) *discordgo.MessageEmbed {
+func (a *AlertData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
 	fields := []*discordgo.MessageEmbedField{}
 
 	color := 0x00ff00 // Assume green (AKA, "currently running").
@@ -66,11 +164,7 @@ func (a *AlertData) AsEmbed(log bool) *discordgo.MessageEmbed {
 	// If it hasn't started yet, add a field with the time till start.
 	if a.Activation.After(time.Now()) {
 		color = 0x0000ff
-		fields = append(fields, &discordgo.MessageEmbedField{
-			Name:   "Starting in:",
-			Value:  fmt.Sprintf("%dm", a.Activation.Sub(time.Now()).Round(time.Minute)/time.Minute),
-			Inline: true,
-		})
+		fields = append(fields, style.timeField("Starting in:", "Starts:", a.Activation, fmt.Sprintf("%dm", a.Activation.Sub(time.Now()).Round(time.Minute)/time.Minute)))
 	}
 
 	if log {
@@ -81,11 +175,7 @@ func (a *AlertData) AsEmbed(log bool) *discordgo.MessageEmbed {
 	if a.Expired || a.Expiry.Sub(time.Now()).Round(time.Minute) < time.Minute {
 		color = 0xff0000
 	} else {
-		fields = append(fields, &discordgo.MessageEmbedField{
-			Name:   "Remaining Time:",
-			Value:  fmt.Sprintf("%dm", a.Expiry.Sub(time.Now()).Round(time.Minute)/time.Minute),
-			Inline: true,
-		})
+		fields = append(fields, style.timeField("Remaining Time:", "Ends:", a.Expiry, fmt.Sprintf("%dm", a.Expiry.Sub(time.Now()).Round(time.Minute)/time.Minute)))
 	}
 
 	// fields = append(fields, &discordgo.MessageEmbedField{
@@ -102,12 +192,20 @@ func (a *AlertData) AsEmbed(log bool) *discordgo.MessageEmbed {
 	}
 }
 
+func (a *AlertData) Transitions() []time.Time {
+	return []time.Time{a.Activation, a.Expiry}
+}
+
 func (a *AlertData) FilterString() string {
 	return a.Mission.Reward.Desc
 }
 
-func (a *AlertData) GetID() (string, bool) {
-	return a.ID, true
+func (a *AlertData) FilterFields() FilterFields {
+	return FilterFields{Reward: a.Mission.Reward.Desc, Node: a.Mission.Node, Faction: a.Mission.Faction, Type: a.Mission.Type}
+}
+
+func (a *AlertData) GetID() (string, int) {
+	return a.ID, TypeAlert
 }
 
 type AlertMissionData struct {
@@ -124,37 +222,48 @@ type AlertRewardData struct {
 type InvasionData struct {
 	ID             string          `json:"id"`
 	Node           string          `json:"node"`
+	Attacker       string          `json:"attackingFaction"`
 	Defender       string          `json:"defendingFaction"`
 	VSInfestation  bool            `json:"vsInfestation"`
 	Activation     time.Time       `json:"activation"`
 	AttackerReward AlertRewardData `json:"attackerReward"`
 	DefenderReward AlertRewardData `json:"defenderReward"`
 	Completed      bool            `json:"completed"`
+	Completion     float64         `json:"completion"` // Attacker progress, in percent.
+	Count          int             `json:"count"`
+	RequiredRuns   int             `json:"requiredRuns"`
 	ETA            string          `json:"eta"`
+
+	// When it looks like it will finish, from trackInvasions. Zero if we can't tell yet.
+	projected time.Time
 }
 
-func (a *InvasionData) AsEmbed(log bool) *discordgo.MessageEmbed {
+func (a *InvasionData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
 	fields := []*discordgo.MessageEmbedField{}
 
-	color := 0x00ff00 // Assume green (AKA, "currently running").
+	color := getFaction(a.leader()).Color // Assume running, in the colour of whoever is winning.
 
 	// If it hasn't started yet, add a field with the time till start.
 	if a.Activation.After(time.Now()) {
 		color = 0x0000ff
-		fields = append(fields, &discordgo.MessageEmbedField{
-			Name:   "Starting in:",
-			Value:  fmt.Sprintf("%dm", a.Activation.Sub(time.Now()).Round(time.Minute)/time.Minute),
-			Inline: true,
-		})
+		fields = append(fields, style.timeField("Starting in:", "Starts:", a.Activation, fmt.Sprintf("%dm", a.Activation.Sub(time.Now()).Round(time.Minute)/time.Minute)))
 	}
 
 	if log {
 		//fmt.Println()
 	}
 
-	// If it has expired change the color to red.
+	// If it has expired change the color to red, otherwise show how it is going.
 	if a.Completed {
 		color = 0xff0000
+	} else if a.RequiredRuns > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Progress:",
+			Value: a.progressBar(),
+		})
+		if a.projected.After(time.Now()) {
+			fields = append(fields, style.timeField("Projected end in:", "Projected end:", a.projected, formatDuration(a.projected.Sub(time.Now()))))
+		}
 	}
 
 	// fields = append(fields, &discordgo.MessageEmbedField{
@@ -163,17 +272,12 @@ func (a *InvasionData) AsEmbed(log bool) *discordgo.MessageEmbed {
 	// 	Inline: false,
 	// })
 
-	msg := ""
-	if a.VSInfestation {
-		msg = a.DefenderReward.Desc
-	} else {
-		msg = a.AttackerReward.Desc + " vs " + a.DefenderReward.Desc
-	}
+	desc := "At " + a.Node + "\n" + getFaction(a.Attacker).Icon + " " + a.attackerSide() + "\n" + getFaction(a.Defender).Icon + " " + a.defenderSide()
 
 	return &discordgo.MessageEmbed{
-		Color:       color, // Blue when not started, Green while running, Red when finished.
+		Color:       color, // Blue when not started, faction colour while running, Red when finished.
 		Title:       "Invasion:",
-		Description: "At " + a.Node + " for " + msg,
+		Description: desc,
 		Fields:      fields,
 	}
 }
@@ -188,6 +292,91 @@ func (a *InvasionData) FilterString() string {
 	return msg
 }
 
-func (a *InvasionData) GetID() (string, bool) {
-	return a.ID, false
+func (a *InvasionData) FilterFields() FilterFields {
+	faction := a.Defender
+	if !a.VSInfestation {
+		faction = a.Attacker + " vs " + a.Defender
+	}
+	return FilterFields{Reward: a.FilterString(), Node: a.Node, Faction: faction, Attacker: a.attackerSide(), Defender: a.defenderSide()}
+}
+
+func (a *InvasionData) GetID() (string, int) {
+	return a.ID, TypeInvasion
+}
+
+type FissureData struct {
+	ID          string    `json:"id"`
+	Activation  time.Time `json:"activation"`
+	Expiry      time.Time `json:"expiry"`
+	Node        string    `json:"node"`
+	MissionType string    `json:"missionType"`
+	Enemy       string    `json:"enemy"`
+	Tier        string    `json:"tier"` // Lith, Meso, Neo, Axi, Requiem, or Omnia
+	TierNum     int       `json:"tierNum"`
+	Storm       bool      `json:"isStorm"`
+	SteelPath   bool      `json:"isHard"`
+	Expired     bool      `json:"expired"`
+	ETA         string    `json:"eta"`
+}
+
+func (a *FissureData) AsEmbed(log bool, style EmbedStyle) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{}
+
+	color := 0x00ff00 // Assume green (AKA, "currently running").
+
+	// If it hasn't started yet, add a field with the time till start.
+	if a.Activation.After(time.Now()) {
+		color = 0x0000ff
+		fields = append(fields, style.timeField("Starting in:", "Starts:", a.Activation, fmt.Sprintf("%dm", a.Activation.Sub(time.Now()).Round(time.Minute)/time.Minute)))
+	}
+
+	// If it has expired change the color to red, otherwise add a field with the time until it ends.
+	if a.Expired || a.Expiry.Sub(time.Now()).Round(time.Minute) < time.Minute {
+		color = 0xff0000
+	} else {
+		fields = append(fields, style.timeField("Remaining Time:", "Ends:", a.Expiry, fmt.Sprintf("%dm", a.Expiry.Sub(time.Now()).Round(time.Minute)/time.Minute)))
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:       color, // Blue when not started, Green while running, Red when finished.
+		Title:       a.title(),
+		Description: a.Tier + " " + a.MissionType + " at " + a.Node + " (" + a.Enemy + ")",
+		Fields:      fields,
+	}
+}
+
+func (a *FissureData) Transitions() []time.Time {
+	return []time.Time{a.Activation, a.Expiry}
+}
+
+func (a *FissureData) title() string {
+	switch {
+	case a.Storm:
+		return "Void Storm:"
+	case a.SteelPath:
+		return "Steel Path Fissure:"
+	default:
+		return "Void Fissure:"
+	}
+}
+
+// Things like "Axi Survival at Node (Enemy) steel path" so filters for "axi survival" or "steel path" work.
+func (a *FissureData) FilterString() string {
+	msg := a.Tier + " " + a.MissionType + " at " + a.Node + " (" + a.Enemy + ")"
+	if a.SteelPath {
+		msg += " steel path"
+	}
+	if a.Storm {
+		msg += " void storm"
+	}
+	return msg
+}
+
+// The reward is the relic tier.
+func (a *FissureData) FilterFields() FilterFields {
+	return FilterFields{Reward: a.Tier, Node: a.Node, Faction: a.Enemy, Type: a.MissionType}
+}
+
+func (a *FissureData) GetID() (string, int) {
+	return a.ID, TypeFissure
 }